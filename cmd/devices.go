@@ -0,0 +1,88 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"input2com/internal/device"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/kenshaw/evdev"
+	"github.com/spf13/cobra"
+)
+
+var devicesCmd = &cobra.Command{
+	Use:   "devices",
+	Short: "列出所有输入设备及其识别结果",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "PATH\tNAME\tID\tPHYS\tTYPE\tCAPABILITIES\tGRAB\tRULES")
+		for _, index := range device.Indexes() {
+			info, err := device.Probe(index)
+			if err != nil {
+				fmt.Fprintf(w, "%s\t<%v>\n", device.Path(index), err)
+				continue
+			}
+			fmt.Fprintf(w, "%s\t%s\t%04x:%04x\t%s\t%s\t%s\t%t\t%s\n",
+				info.Path, info.Name, info.ID.Vendor, info.ID.Product, info.Phys,
+				info.Type, info.Capabilities, info.Grab, strings.Join(info.Rules, ","))
+		}
+		return w.Flush()
+	},
+}
+
+var watchGrab bool
+
+var devicesWatchCmd = &cobra.Command{
+	Use:   "watch <eventN|序号|路径>",
+	Short: "实时打印某个设备的事件",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path := args[0]
+		if index, err := strconv.Atoi(strings.TrimPrefix(path, "event")); err == nil {
+			path = device.Path(index)
+		}
+		fd, err := os.OpenFile(path, os.O_RDONLY, 0)
+		if err != nil {
+			return err
+		}
+		d := evdev.Open(fd)
+		defer d.Close()
+		if watchGrab {
+			d.Lock()
+			defer d.Unlock()
+		}
+		fmt.Printf("%s (%s) 按 Ctrl+C 退出\n", d.Name(), path)
+
+		ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer cancel()
+		eventCh := d.Poll(ctx)
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case event := <-eventCh:
+				if event == nil {
+					return fmt.Errorf("设备已移除")
+				}
+				sec, nsec := event.Time.Unix()
+				stamp := time.Unix(sec, nsec).Format("15:04:05.000000")
+				if event.Event.Type == evdev.EventSync {
+					fmt.Printf("%s -------------- %v\n", stamp, event.Type)
+					continue
+				}
+				fmt.Printf("%s %-10v %-20v %d\n", stamp, event.Event.Type, event.Type, event.Value)
+			}
+		}
+	},
+}
+
+func init() {
+	devicesWatchCmd.Flags().BoolVar(&watchGrab, "grab", false, "独占设备，事件不再传给系统")
+	devicesCmd.AddCommand(devicesWatchCmd)
+	RootCmd.AddCommand(devicesCmd)
+}
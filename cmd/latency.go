@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"input2com/internal/config"
+	"input2com/internal/latency"
+	"net/http"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+// apiURL 返回本机正在运行的 input2com 的 HTTP 接口地址
+func apiURL(path string) string {
+	return fmt.Sprintf("http://127.0.0.1:%d/api%s", config.Cfg.Server.Port, path)
+}
+
+var latencyCmd = &cobra.Command{
+	Use:   "latency",
+	Short: "查看正在运行的实例的输入延迟统计",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		resp, err := http.Get(apiURL("/get/latency"))
+		if err != nil {
+			return fmt.Errorf("无法连接到 input2com: %w", err)
+		}
+		defer resp.Body.Close()
+		var snapshot map[string]map[string]latency.Summary
+		if err := json.NewDecoder(resp.Body).Decode(&snapshot); err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "KIND\tNAME\tCOUNT\tP50(ms)\tP95(ms)\tP99(ms)\tMAX(ms)")
+		for _, kind := range []string{latency.KindDevice, latency.KindStage, latency.KindBackend} {
+			names := make([]string, 0, len(snapshot[kind]))
+			for name := range snapshot[kind] {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				s := snapshot[kind][name]
+				fmt.Fprintf(w, "%s\t%s\t%d\t%.3f\t%.3f\t%.3f\t%.3f\n", kind, name, s.Count, s.P50, s.P95, s.P99, s.Max)
+			}
+		}
+		return w.Flush()
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(latencyCmd)
+}
@@ -0,0 +1,94 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"input2com/internal/recorder"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var recordOpts recorder.Options
+
+// apiGet 请求正在运行的实例，非200时返回响应内容作为错误
+func apiGet(path string, query url.Values) ([]byte, error) {
+	resp, err := http.Get(apiURL(path) + "?" + query.Encode())
+	if err != nil {
+		return nil, fmt.Errorf("无法连接到 input2com: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s", body)
+	}
+	return body, nil
+}
+
+var recordCmd = &cobra.Command{
+	Use:   "record <宏名称>",
+	Short: "在正在运行的实例上录制宏，按停止热键或 Ctrl+C 结束",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		query := url.Values{}
+		query.Set("name", args[0])
+		query.Set("description", recordOpts.Description)
+		query.Set("devName", recordOpts.Device)
+		query.Set("stopKey", recordOpts.StopKey)
+		query.Set("dropMotion", fmt.Sprint(recordOpts.DropMotion))
+		if recordOpts.Quantize > 0 {
+			query.Set("quantize", recordOpts.Quantize.String())
+		}
+		if _, err := apiGet("/record/start", query); err != nil {
+			return err
+		}
+		fmt.Printf("开始录制 %s，按 %s 或 Ctrl+C 停止\n", args[0], recordOpts.StopKey)
+
+		interrupt := make(chan os.Signal, 1)
+		signal.Notify(interrupt, os.Interrupt)
+		ticker := time.NewTicker(200 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-interrupt:
+				if _, err := apiGet("/record/stop", nil); err != nil {
+					return err
+				}
+			case <-ticker.C:
+			}
+			body, err := apiGet("/get/record", nil)
+			if err != nil {
+				return err
+			}
+			var status recorder.Status
+			if err := json.Unmarshal(body, &status); err != nil {
+				return err
+			}
+			if status.Recording {
+				continue
+			}
+			if status.Error != "" {
+				return fmt.Errorf("保存失败: %s", status.Error)
+			}
+			fmt.Printf("已保存宏 %s，共 %d 步\n", status.Last, status.Steps)
+			return nil
+		}
+	},
+}
+
+func init() {
+	recordCmd.Flags().StringVar(&recordOpts.Device, "device", "", "只录制该设备，默认所有设备")
+	recordCmd.Flags().StringVar(&recordOpts.Description, "description", "", "宏描述")
+	recordCmd.Flags().DurationVar(&recordOpts.Quantize, "quantize", 0, "等待时间取整粒度，如 10ms")
+	recordCmd.Flags().BoolVar(&recordOpts.DropMotion, "drop-motion", false, "不录制鼠标移动")
+	recordCmd.Flags().StringVar(&recordOpts.StopKey, "stop-key", recorder.DefaultStopKey, "停止录制的热键")
+	RootCmd.AddCommand(recordCmd)
+}
@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	typeLayout string
+	typeDelay  time.Duration
+)
+
+var typeCmd = &cobra.Command{
+	Use:   "type <文本>",
+	Short: "在目标端输入一段文本",
+	Args:  cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		query := url.Values{}
+		query.Set("text", strings.Join(args, " "))
+		query.Set("layout", typeLayout)
+		if typeDelay > 0 {
+			query.Set("delay", typeDelay.String())
+		}
+		if _, err := apiGet("/type", query); err != nil {
+			return err
+		}
+		fmt.Println("ok")
+		return nil
+	},
+}
+
+func init() {
+	typeCmd.Flags().StringVar(&typeLayout, "layout", "", "目标端键盘布局(us, uk, de, fr, jp)，默认使用配置")
+	typeCmd.Flags().DurationVar(&typeDelay, "delay", 0, "每个按键的间隔，默认使用配置")
+	RootCmd.AddCommand(typeCmd)
+}
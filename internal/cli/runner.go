@@ -2,17 +2,20 @@ package cli
 
 import (
 	"context"
-	"fmt"
 	"os"
 	"os/signal"
 	"path/filepath"
-	"strconv"
 	"syscall"
 	"time"
 
-	"input2com/internal/input"
+	"input2com/internal/config"
+	"input2com/internal/device"
 	"input2com/internal/logger"
 	"input2com/internal/macros"
+	"input2com/internal/pipeline"
+	"input2com/internal/plugin"
+	"input2com/internal/recorder"
+	"input2com/internal/sensitivity"
 	"input2com/internal/serial"
 	"input2com/internal/server"
 
@@ -21,26 +24,12 @@ import (
 	"github.com/kenshaw/evdev"
 )
 
-type eventPack struct {
-	//表示一个动作 由一系列event组成
-	devName string
-	events  []*evdev.Event
-}
-
-func devReader(eventReader chan *eventPack, index int) {
-	fd, err := os.OpenFile(fmt.Sprintf("/dev/input/event%d", index), os.O_RDONLY, 0)
-	if err != nil {
-		logger.Logger.Errorf("读取设备失败 : %v", err)
-		return
-	}
-	d := evdev.Open(fd)
-	defer d.Close()
-	eventCh := d.Poll(context.Background())
-	events := make([]*evdev.Event, 0)
-	devName := d.Name()
+func devReader(eventReader chan *pipeline.Packet, dev *device.Handle) {
+	defer dev.Close()
+	eventCh := dev.Poll(context.Background())
+	events := make([]pipeline.Event, 0)
+	devName := dev.Info.Name
 	logger.Logger.Infof("开始读取设备 : %s", devName)
-	d.Lock()
-	defer d.Unlock()
 	for {
 		select {
 		case <-globalCloseSignal:
@@ -48,17 +37,18 @@ func devReader(eventReader chan *eventPack, index int) {
 			return
 		case event := <-eventCh:
 			if event == nil {
-				logger.Logger.Warnf("移除设备 : %s", devName)
 				return
 			} else if event.Type == evdev.SyncReport {
-				pack := &eventPack{
-					devName: devName,
-					events:  events,
+				pack := &pipeline.Packet{
+					Device: devName,
+					Events: events,
+					Time:   time.Unix(event.Time.Unix()),
 				}
+				dev.Observe(pack.Time)
 				eventReader <- pack
-				events = make([]*evdev.Event, 0)
+				events = make([]pipeline.Event, 0)
 			} else {
-				events = append(events, &event.Event)
+				events = append(events, pipeline.Event{Type: event.Event.Type, Code: event.Code, Value: event.Value})
 			}
 		}
 	}
@@ -66,155 +56,83 @@ func devReader(eventReader chan *eventPack, index int) {
 
 var globalCloseSignal = make(chan bool) //仅会在程序退出时关闭  不用于其他用途
 
-type devType uint8
-
-const (
-	typeMouse    = devType(0)
-	typeKeyboard = devType(1)
-	typeJoystick = devType(2)
-	typeTouch    = devType(3)
-	typeUnknown  = devType(4)
-)
-
-func checkDevType(dev *evdev.Evdev) devType {
-	abs := dev.AbsoluteTypes()
-	key := dev.KeyTypes()
-	rel := dev.RelativeTypes()
-	_, MTPositionX := abs[evdev.AbsoluteMTPositionX]
-	_, MTPositionY := abs[evdev.AbsoluteMTPositionY]
-	_, MTSlot := abs[evdev.AbsoluteMTSlot]
-	_, MTTrackingID := abs[evdev.AbsoluteMTTrackingID]
-	if MTPositionX && MTPositionY && MTSlot && MTTrackingID {
-		return typeTouch //触屏检测这几个abs类型即可
-	}
-	_, RelX := rel[evdev.RelativeX]
-	_, RelY := rel[evdev.RelativeY]
-	_, HWheel := rel[evdev.RelativeHWheel]
-	_, MouseLeft := key[evdev.BtnLeft]
-	_, MouseRight := key[evdev.BtnRight]
-	_, MouseMiddle := key[evdev.BtnMiddle]
-	if RelX && RelY && HWheel && MouseLeft && MouseRight && MouseMiddle {
-		return typeMouse //鼠标 检测XY 滚轮 左右中键
-	}
-	keyboardKeys := true
-	for i := evdev.KeyEscape; i <= evdev.KeyScrollLock; i++ {
-		_, ok := key[i]
-		keyboardKeys = keyboardKeys && ok
-	}
-	if keyboardKeys {
-		return typeKeyboard //键盘 检测keycode(1-70)
-	}
-
-	axisCount := 0
-	for i := evdev.AbsoluteX; i <= evdev.AbsoluteRZ; i++ {
-		_, ok := abs[i]
-		if ok {
-			axisCount++
-		}
-	}
-	LsRs := axisCount >= 4
-
-	keyCount := 0
-	for i := evdev.BtnA; i <= evdev.BtnZ; i++ {
-		_, ok := key[i]
-		if ok {
-			keyCount++
-		}
-	}
-	ABXY := keyCount >= 4
-
-	if LsRs && ABXY {
-		return typeJoystick //手柄 检测LS,RS A,B,X,Y
-	}
-	return typeUnknown
-}
-
-func getPossibleDeviceIndexes(skipList map[int]bool) map[int]devType {
-	files, _ := os.ReadDir("/dev/input")
-	result := make(map[int]devType)
-	for _, file := range files {
-		if file.IsDir() {
+// getPossibleDevices 返回尚未读取且会被读取的设备
+func getPossibleDevices() []*device.Info {
+	result := make([]*device.Info, 0)
+	for _, index := range device.Indexes() {
+		if device.Devices.IsOpen(index) {
 			continue
 		}
-		if len(file.Name()) <= 5 {
+		info, err := device.Probe(index)
+		if err != nil {
+			logger.Logger.Errorf("读取设备%s失败 : %v ", device.Path(index), err)
 			continue
 		}
-		if file.Name()[:5] != "event" {
-			continue
-		}
-		index, _ := strconv.Atoi(file.Name()[5:])
-		reading, exist := skipList[index]
-		if exist && reading {
-			continue
-		} else {
-			fd, err := os.OpenFile(fmt.Sprintf("/dev/input/%s", file.Name()), os.O_RDONLY, 0)
-			if err != nil {
-				logger.Logger.Errorf("读取设备/dev/input/%s失败 : %v ", file.Name(), err)
-			}
-			d := evdev.Open(fd)
-			defer d.Close()
-			devType := checkDevType(d)
-			if devType != typeUnknown {
-				result[index] = devType
-			}
+		if info.Grab {
+			result = append(result, info)
 		}
 	}
 	return result
 }
 
-func getDevNameByIndex(index int) string {
-	fd, err := os.OpenFile(fmt.Sprintf("/dev/input/event%d", index), os.O_RDONLY, 0)
-	if err != nil {
-		return "读取设备名称失败"
-	}
-	d := evdev.Open(fd)
-	defer d.Close()
-	return d.Name()
-}
-
-func autoDetectAndRead(eventChan chan *eventPack) {
+func autoDetectAndRead(eventChan chan *pipeline.Packet) {
 	//自动检测设备并读取 循环检测 自动管理设备插入移除
-	devices := make(map[int]bool)
 	for {
 		select {
 		case <-globalCloseSignal:
 			return
 		default:
-			autoDetectResult := getPossibleDeviceIndexes(devices)
-			devTypeFriendlyName := map[devType]string{
-				typeMouse:    "鼠标",
-				typeKeyboard: "键盘",
-				typeJoystick: "手柄",
-				typeTouch:    "触屏",
-				typeUnknown:  "未知",
-			}
-			for index, devType := range autoDetectResult {
-				devName := getDevNameByIndex(index)
-				if devName == "input2com-virtual-device" {
-					continue //跳过生成的虚拟设备
+			for _, info := range getPossibleDevices() {
+				dev, err := device.Devices.Open(info)
+				if err != nil {
+					logger.Logger.Errorf("读取设备失败 : %v", err)
+					continue
 				}
-				if devType == typeMouse || devType == typeKeyboard || devType == typeJoystick {
-					logger.Logger.Infof("检测到设备 %s(/dev/input/event%d) : %s", devName, index, devTypeFriendlyName[devType])
-					localIndex := index
-					go func() {
-						devices[localIndex] = true
-						macros.MouseConfigDict[devName] = make(map[byte]string)
-						devReader(eventChan, localIndex)
-						devices[localIndex] = false
-					}()
+				if dev == nil {
+					continue
 				}
+				go devReader(eventChan, dev)
 			}
 			time.Sleep(time.Duration(400) * time.Millisecond)
 		}
 	}
 }
 
-func Run(debug bool, baudrate int, ttyPath string, mouseConfigDict map[string]map[byte]string) {
+// watchDevices 处理设备插入/移除事件
+func watchDevices(events <-chan device.Event, macroKB *macros.MacroMouseKeyboard) {
+	for e := range events {
+		switch e.Kind {
+		case device.EventAdded:
+			logger.Logger.Infof("检测到设备 %s(%s) : %s", e.Device.Name, e.Device.Path, e.Device.Type)
+			macros.BindDevice(e.Device.Name)
+		case device.EventRemoved:
+			logger.Logger.Warnf("移除设备 : %s(%s)", e.Device.Name, e.Device.Path)
+			macroKB.ReleaseDevice(e.Device.Name) //避免目标端按键卡住
+		}
+	}
+}
+
+func Run(debug bool, baudrate int, ttyPath string, mouseConfigDict map[string]map[byte]string, keyboardConfigDict map[byte]string) {
 	go server.Serve() //启动配置服务器
 
 	if debug {
 		logger.Logger.WithDebug()
 	}
+	if err := sensitivity.Load(config.Cfg.Sensitivity); err != nil {
+		logger.Logger.Fatalf("灵敏度配置错误: %v", err)
+	}
+	if err := macros.LoadLayers(config.Cfg.Layers); err != nil {
+		logger.Logger.Fatalf("层配置错误: %v", err)
+	}
+	if mouseConfigDict != nil {
+		macros.MouseConfigDict = mouseConfigDict
+	}
+	if keyboardConfigDict != nil {
+		macros.KeyboardConfigDict = keyboardConfigDict
+	}
+	if err := macros.LoadProfiles(config.Cfg.Profiles); err != nil {
+		logger.Logger.Fatalf("方案配置错误: %v", err)
+	}
 
 	matches, err := filepath.Glob(ttyPath)
 	if err != nil {
@@ -228,8 +146,6 @@ func Run(debug bool, baudrate int, ttyPath string, mouseConfigDict map[string]ma
 	logger.Logger.Infof("使用设备路径: %s", devpath)
 	logger.Logger.Infof("波特率: %d", baudrate)
 
-	eventsCh := make(chan *eventPack) //主要设备事件管道
-	go autoDetectAndRead(eventsCh)
 	//comKB := serial.NewComMouseKeyboard(devpath, baudrate)
 	makcuKB, err := serial.Connect(devpath, baudrate)
 	if makcuKB == nil {
@@ -243,13 +159,33 @@ func Run(debug bool, baudrate int, ttyPath string, mouseConfigDict map[string]ma
 	go makcuKB.ListenLoop()
 	defer makcuKB.Close()
 	//macroKB := macros.NewMacroMouseKeyboard(comKB)
-	macroKB := macros.NewMacroMouseKeyboard(makcuKB)
+	macroKB := macros.NewMacroMouseKeyboard(macros.NewTimedCtrl(macros.NewTargetCtrl(makcuKB), "makcu")) //切换目标用 SwitchTarget
+	server.SetMacroKeyboard(macroKB)
+	if err := macros.LoadCombos(config.Cfg.Combo.Combos); err != nil {
+		logger.Logger.Fatalf("组合键配置错误: %v", err)
+	}
+	if err := macros.LoadLeader(config.Cfg.Leader); err != nil {
+		logger.Logger.Fatalf("leader 配置错误: %v", err)
+	}
+	if err := macros.Watch(globalCloseSignal); err != nil {
+		logger.Logger.Errorf("无法监听宏文件，修改后需要重启: %v", err)
+	}
+
+	eventsCh := make(chan *pipeline.Packet) //主要设备事件管道
+	go watchDevices(device.Devices.Subscribe(context.Background()), macroKB)
+	go autoDetectAndRead(eventsCh)
+
+	pluginHost := plugin.NewHost(macroKB, config.Cfg.Plugins)
+	if err := pluginHost.Start(); err != nil {
+		logger.Logger.Errorf("启动插件失败: %v", err)
+	} else {
+		server.SetPluginHost(pluginHost)
+		defer pluginHost.Stop()
+	}
 
 	remoteCtl := remote.NewRemoteControl(macroKB)
 	go remoteCtl.Start()
 	defer remoteCtl.Stop()
-	macros.MouseConfigDict = mouseConfigDict
-
 	//Makcu 的回调事件,只会触发宏，不会触发设备事件
 	handelMakcuEvent := func(btn serial.MouseButton, pressed bool) {
 		//logger.Logger.Infof("btn %d, pressed: %t", btn, pressed)
@@ -260,117 +196,36 @@ func Run(debug bool, baudrate int, ttyPath string, mouseConfigDict map[string]ma
 		}
 	}
 	makcuKB.SetButtonCallback(handelMakcuEvent)
-	handelRelEvent := func(x, y, HWhell, Wheel int32) {
-		if x != 0 || y != 0 || HWhell != 0 || Wheel != 0 {
-			macroKB.MouseMove(x, y, Wheel)
-		}
-	}
-	handelKeyEvents := func(events []*evdev.Event, devName string) {
-		for _, event := range events {
-			if event.Value == 0 {
-				logger.Logger.Debugf("%v 按键释放: %v", devName, event.Code)
-				if event.Code == uint16(evdev.BtnLeft) { // 鼠标左键释放
-					macroKB.MouseBtnUp(input.MouseBtnLeft, devName)
-				} else if event.Code == uint16(evdev.BtnRight) { // 鼠标右键释放
-					macroKB.MouseBtnUp(input.MouseBtnRight, devName)
-				} else if event.Code == uint16(evdev.BtnMiddle) { // 鼠标中键释放
-					macroKB.MouseBtnUp(input.MouseBtnMiddle, devName)
-				} else if event.Code == uint16(evdev.BtnSide) { // 鼠标后退键释放
-					macroKB.MouseBtnUp(input.MouseBtnBack, devName)
-				} else if event.Code == uint16(evdev.BtnExtra) { // 鼠标前进键释放
-					macroKB.MouseBtnUp(input.MouseBtnForward, devName)
-				} else {
-					macroKB.KeyUp(event.Code) // 其他按键释放
-				}
-			} else if event.Value == 1 {
-				logger.Logger.Debugf("%v 按键按下: %v", devName, event.Code)
-				if event.Code == uint16(evdev.BtnLeft) { // 鼠标左键释放
-					macroKB.MouseBtnDown(input.MouseBtnLeft, devName)
-				} else if event.Code == uint16(evdev.BtnRight) { // 鼠标右键释放
-					macroKB.MouseBtnDown(input.MouseBtnRight, devName)
-				} else if event.Code == uint16(evdev.BtnMiddle) { // 鼠标中键释放
-					macroKB.MouseBtnDown(input.MouseBtnMiddle, devName)
-				} else if event.Code == uint16(evdev.BtnSide) { // 鼠标后退键释放
-					macroKB.MouseBtnDown(input.MouseBtnBack, devName)
-				} else if event.Code == uint16(evdev.BtnExtra) { // 鼠标前进键释放
-					macroKB.MouseBtnDown(input.MouseBtnForward, devName)
-				} else {
-					macroKB.KeyDown(event.Code) // 其他按键释放
-				}
-			} else if event.Value == 2 {
-				//logger.Logger.Debugf("%v 按键重复: %v", devName, event.Code)
-			}
-		}
-	}
-
-	handelAbsEvents := func(events []*evdev.Event, devName string) {
-		if len(events) == 0 {
-			return
-		}
-		for _, event := range events {
-			if event.Type != evdev.EventAbsolute {
-				continue
-			}
-		}
-	}
+	// 事件处理管道: 设备过滤 -> 按键重映射 -> 灵敏度 -> 高精度滚轮 -> 录制 -> 输出移动 -> 宏分发
+	pipe := pipeline.New(
+		pipeline.NewDeviceFilter(config.Cfg.IgnoreDevices),
+		pipeline.NewRemap(config.Cfg.Remap),
+		pipeline.NewSensitivity(),
+		pipeline.NewHiResWheel(macroKB),
+		recorder.Stage(),
+		pipeline.NewOutput(macroKB),
+		pipeline.NewMacroDispatch(macroKB),
+	)
 
 	go func() {
 		for {
-			keyEvents := make([]*evdev.Event, 0)
-			absEvents := make([]*evdev.Event, 0)
-			var x int32 = 0
-			var y int32 = 0
-			var HWhell int32 = 0
-			var Wheel int32 = 0
 			select {
 			case <-globalCloseSignal:
 				return
-			case eventPack := <-eventsCh:
-				if eventPack == nil {
+			case pack := <-eventsCh:
+				if pack == nil {
 					continue
 				}
-				for _, event := range eventPack.events {
-					switch event.Type {
-					case evdev.EventKey:
-						keyEvents = append(keyEvents, event)
-					case evdev.EventAbsolute:
-						absEvents = append(absEvents, event)
-					case evdev.EventRelative:
-						switch event.Code {
-						case uint16(evdev.RelativeX):
-							x = event.Value
-						case uint16(evdev.RelativeY):
-							y = event.Value
-						case uint16(evdev.RelativeHWheel):
-							HWhell = event.Value
-						case uint16(evdev.RelativeWheel):
-							Wheel = event.Value
-						}
-					}
-				}
-				var perfPoint time.Time
-
-				perfPoint = time.Now()
-				handelRelEvent(x, y, HWhell, Wheel)
-				relSin := time.Since(perfPoint)
-				perfPoint = time.Now()
-				handelKeyEvents(keyEvents, eventPack.devName)
-				keySin := time.Since(perfPoint)
-				perfPoint = time.Now()
-				handelAbsEvents(absEvents, eventPack.devName)
-				absSin := time.Since(perfPoint)
-				logger.Logger.Debugf("")
-				logger.Logger.Debugf("handel rel_event\t%v \n", relSin)
-				logger.Logger.Debugf("handel key_events\t%v \n", keySin)
-				logger.Logger.Debugf("handel abs_events\t%v \n", absSin)
+				pipe.Run(pack)
 			}
 		}
 	}()
 
-	exitChan := make(chan os.Signal)
+	exitChan := make(chan os.Signal, 1)
 	signal.Notify(exitChan, os.Interrupt, os.Kill, syscall.SIGTERM)
 	<-exitChan
 	close(globalCloseSignal)
+	time.Sleep(time.Millisecond * 40) //等待读取协程退出
+	macroKB.ReleaseAll()
 	logger.Logger.Info("已停止")
-	time.Sleep(time.Millisecond * 40)
 }
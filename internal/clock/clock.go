@@ -0,0 +1,265 @@
+package clock
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Clock 宏计时使用的时钟，测试时用 Fake 代替真实时间
+type Clock interface {
+	Now() time.Time
+	// After d 之后向返回的通道发送当时的时间，d <= 0 时立即发送
+	After(d time.Duration) <-chan time.Time
+}
+
+// Real 系统时钟，Now 带有单调时钟读数，不受系统时间调整影响
+var Real Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// Sleep 在 c 上等待 d
+func Sleep(c Clock, d time.Duration) {
+	if d > 0 {
+		<-c.After(d)
+	}
+}
+
+// Timer AfterFunc 返回的定时器，*time.Timer 也满足
+type Timer interface {
+	// Stop 阻止 f 被调用，f 已经被调用或定时器已停止时返回 false
+	Stop() bool
+}
+
+// AfterFunc 在 c 上经过 d 之后在新的协程中调用 f。Real 直接使用 time.AfterFunc，
+// Fake 上 f 返回后回到时钟，定时器的到期和宏的等待一样由 Advance 按顺序触发
+func AfterFunc(c Clock, d time.Duration, f func()) Timer {
+	if c == Real {
+		return time.AfterFunc(d, f)
+	}
+	t := &clockTimer{clock: c, ch: c.After(d), stop: make(chan struct{})}
+	go func() {
+		select {
+		case <-t.ch:
+			if t.state.CompareAndSwap(0, 1) {
+				f()
+				Idle(c)
+			}
+		case <-t.stop:
+		}
+	}()
+	return t
+}
+
+type clockTimer struct {
+	clock Clock
+	ch    <-chan time.Time
+	state atomic.Int32 // 0 等待中, 1 已调用 f, 2 已停止
+	stop  chan struct{}
+}
+
+// Stop 在调用方的协程中放弃等待，返回后 Fake 上已经没有这个等待者
+func (t *clockTimer) Stop() bool {
+	if !t.state.CompareAndSwap(0, 2) {
+		return false
+	}
+	abandon(t.clock, t.ch)
+	close(t.stop)
+	return true
+}
+
+// maxLag 落后截止时间超过这个值(如系统卡顿)时从当前时间重新计算，不连续补发落下的步骤
+const maxLag = 50 * time.Millisecond
+
+// Scheduler 按截止时间安排一连串等待：每次的截止时间从上一次的截止时间算起，
+// 发送报告和调度延迟花费的时间不会累积，循环运行很久也不会漂移。不能并发使用
+type Scheduler struct {
+	clock    Clock
+	deadline time.Time
+}
+
+func NewScheduler(c Clock) *Scheduler {
+	return &Scheduler{clock: c, deadline: c.Now()}
+}
+
+// Next 截止时间推后 d，返回到达截止时间时发送的通道，可以和其他通道一起 select
+func (s *Scheduler) Next(d time.Duration) <-chan time.Time {
+	s.deadline = s.deadline.Add(d)
+	now := s.clock.Now()
+	remaining := s.deadline.Sub(now)
+	if remaining < -maxLag {
+		s.deadline = now
+	}
+	return s.clock.After(remaining)
+}
+
+// Wait 截止时间推后 d 并等待，done 关闭时提前返回 false，done 为 nil 时不会被打断
+func (s *Scheduler) Wait(d time.Duration, done <-chan struct{}) bool {
+	select {
+	case <-done:
+		return false
+	default:
+	}
+	ch := s.Next(d)
+	select {
+	case <-done:
+		abandon(s.clock, ch)
+		return false
+	case <-ch:
+		return true
+	}
+}
+
+// Deadline 当前的截止时间
+func (s *Scheduler) Deadline() time.Time {
+	return s.deadline
+}
+
+// Reset 从当前时间重新计算截止时间，用于等待了时长不定的操作(如调用其他宏)之后
+func (s *Scheduler) Reset() {
+	s.deadline = s.clock.Now()
+}
+
+// Idle 告诉时钟调用方不再等待它(如宏结束或在等待按键松开)，只对 Fake 有作用
+func Idle(c Clock) {
+	if f, ok := c.(*Fake); ok {
+		f.idle()
+	}
+}
+
+// abandon 放弃 After 返回的 ch，只对 Fake 有作用
+func abandon(c Clock, ch <-chan time.Time) {
+	if f, ok := c.(*Fake); ok {
+		f.abandon(ch)
+	}
+}
+
+// Fake 手动推进的时钟，用于测试宏的时序：宏在 Fake 上等待，测试调用 Advance 推进时间，
+// 再检查控制器在这段时间内收到了哪些调用。
+//
+// 被唤醒的等待者要"回到"时钟，Advance 才会继续：再次等待(After)、放弃等待(Scheduler.Wait 被 done 打断)
+// 或调用 Idle。宏通过 Scheduler、Sleep 计时，宏结束和等待松开时调用 Idle，都满足这个约定。
+// 同一时间只应有被唤醒的那个宏在运行，Advance 期间不要从其他协程开始新的等待
+type Fake struct {
+	mutex   sync.Mutex
+	cond    sync.Cond // 等待者或 busy 变化时广播
+	now     time.Time
+	waiters []*waiter
+	busy    int                       // 已唤醒、还没有回到时钟的等待者数量
+	fired   map[<-chan time.Time]bool // 已唤醒的等待者的通道
+}
+
+type waiter struct {
+	deadline time.Time
+	ch       chan time.Time
+}
+
+func NewFake(start time.Time) *Fake {
+	f := &Fake{now: start, fired: make(map[<-chan time.Time]bool)}
+	f.cond.L = &f.mutex
+	return f
+}
+
+func (f *Fake) Now() time.Time {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.now
+}
+
+func (f *Fake) After(d time.Duration) <-chan time.Time {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- f.now // 调用方不会停下，不算回到时钟
+		return ch
+	}
+	f.checkIn()
+	f.waiters = append(f.waiters, &waiter{deadline: f.now.Add(d), ch: ch})
+	sort.SliceStable(f.waiters, func(i, j int) bool { return f.waiters[i].deadline.Before(f.waiters[j].deadline) })
+	f.cond.Broadcast()
+	return ch
+}
+
+// checkIn 一个被唤醒的等待者回到时钟，调用时需持有 mutex
+func (f *Fake) checkIn() {
+	if f.busy == 0 {
+		return
+	}
+	f.busy--
+	if f.busy == 0 {
+		clear(f.fired)
+	}
+	f.cond.Broadcast()
+}
+
+func (f *Fake) idle() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.checkIn()
+}
+
+// abandon 还没到期的等待直接移除，已经唤醒的视为回到时钟
+func (f *Fake) abandon(ch <-chan time.Time) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	for i, w := range f.waiters {
+		if (<-chan time.Time)(w.ch) == ch {
+			f.waiters = append(f.waiters[:i], f.waiters[i+1:]...)
+			f.cond.Broadcast()
+			return
+		}
+	}
+	if f.fired[ch] {
+		delete(f.fired, ch)
+		f.checkIn()
+	}
+}
+
+// Waiters 返回正在等待的数量
+func (f *Fake) Waiters() int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return len(f.waiters)
+}
+
+// BlockUntil 阻塞直到至少有 n 个等待者，用于确认宏已经运行到等待处
+func (f *Fake) BlockUntil(n int) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	for len(f.waiters) < n {
+		f.cond.Wait()
+	}
+}
+
+// Advance 将时间推进 d，按截止时间的顺序逐个唤醒到期的等待者。每唤醒一个都等它回到时钟再继续，
+// 这期间登记的、在推进范围内到期的等待也会被唤醒，所以推进一次可以走完宏的多个步骤。返回时被唤醒的宏都已停下
+func (f *Fake) Advance(d time.Duration) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	target := f.now.Add(d)
+	for {
+		for f.busy > 0 {
+			f.cond.Wait()
+		}
+		if len(f.waiters) == 0 || f.waiters[0].deadline.After(target) {
+			f.now = target
+			return
+		}
+		w := f.waiters[0]
+		f.waiters = f.waiters[1:]
+		f.now = w.deadline
+		f.busy++
+		f.fired[w.ch] = true
+		w.ch <- w.deadline
+	}
+}
@@ -0,0 +1,68 @@
+package clock
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestAdvanceRunsEveryStep(t *testing.T) {
+	start := time.Unix(0, 0)
+	fc := NewFake(start)
+	var woke []time.Duration
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s := NewScheduler(fc)
+		for i := 0; i < 5; i++ {
+			s.Wait(10*time.Millisecond, nil)
+			woke = append(woke, fc.Now().Sub(start))
+		}
+		Idle(fc)
+	}()
+	fc.BlockUntil(1)
+	fc.Advance(50 * time.Millisecond) // 返回时 5 次等待都已经走完
+	want := []time.Duration{10 * time.Millisecond, 20 * time.Millisecond, 30 * time.Millisecond, 40 * time.Millisecond, 50 * time.Millisecond}
+	if !reflect.DeepEqual(woke, want) {
+		t.Errorf("woke at %v, want %v", woke, want)
+	}
+	<-done
+}
+
+func TestWaitAbandoned(t *testing.T) {
+	fc := NewFake(time.Unix(0, 0))
+	cancel := make(chan struct{})
+	result := make(chan bool)
+	go func() { result <- NewScheduler(fc).Wait(time.Second, cancel) }()
+	fc.BlockUntil(1)
+	close(cancel)
+	if <-result {
+		t.Fatal("Wait returned true after done was closed")
+	}
+	if n := fc.Waiters(); n != 0 {
+		t.Errorf("abandoned wait still registered: %d waiters", n)
+	}
+	fc.Advance(2 * time.Second) // 没有被唤醒后不回来的等待者
+}
+
+func TestAfterFunc(t *testing.T) {
+	fc := NewFake(time.Unix(0, 0))
+	fired := make(chan time.Duration, 2)
+	AfterFunc(fc, 30*time.Millisecond, func() { fired <- fc.Now().Sub(time.Unix(0, 0)) })
+	stopped := AfterFunc(fc, 20*time.Millisecond, func() { fired <- 0 })
+	if !stopped.Stop() || fc.Waiters() != 1 {
+		t.Fatal("Stop did not remove the timer")
+	}
+	fc.Advance(50 * time.Millisecond) // 返回时 f 已经执行完
+	select {
+	case d := <-fired:
+		if d != 30*time.Millisecond {
+			t.Errorf("fired at %v, want 30ms", d)
+		}
+	default:
+		t.Fatal("timer did not fire")
+	}
+	if len(fired) != 0 {
+		t.Error("stopped timer fired")
+	}
+}
@@ -1,6 +1,9 @@
 package config
 
 import (
+	"fmt"
+	"time"
+
 	"github.com/spf13/viper"
 )
 
@@ -11,12 +14,213 @@ type Config struct {
 	Server   struct {
 		Port int `mapstructure:"port"`
 	} `mapstructure:"server"`
-	MouseConfigDict map[string]map[byte]string `mapstructure:"mouseConfigDict"`
-	TriggerDelay    int64                      `mapstructure:"triggerDelay"`
-	AimDelay        int32                      `mapstructure:"aimDelay"`
-	AimSpeed        int                        `mapstructure:"aimSpeed"`
+	MouseConfigDict    map[string]map[byte]string   `mapstructure:"mouseConfigDict"`
+	KeyboardConfigDict map[byte]string              `mapstructure:"keyboardConfigDict"`
+	TriggerDelay       int64                        `mapstructure:"triggerDelay"`
+	AimDelay           int32                        `mapstructure:"aimDelay"`
+	AimSpeed           int                          `mapstructure:"aimSpeed"`
+	MacrosDir          string                       `mapstructure:"macrosDir"`
+	Typer              TyperConfig                  `mapstructure:"typer"`
+	Layers             []LayerConfig                `mapstructure:"layers"`
+	TapHold            TapHoldConfig                `mapstructure:"tapHold"`
+	Combo              ComboConfig                  `mapstructure:"combo"`
+	Leader             LeaderConfig                 `mapstructure:"leader"`
+	Scripts            ScriptConfig                 `mapstructure:"scripts"`
+	Plugins            PluginConfig                 `mapstructure:"plugins"`
+	KeyRepeat          KeyRepeatConfig              `mapstructure:"keyRepeat"`
+	Sensitivity        SensitivityConfig            `mapstructure:"sensitivity"`
+	IgnoreDevices      []string                     `mapstructure:"ignoreDevices"`
+	DeviceOverrides    []DeviceOverride             `mapstructure:"deviceOverrides"`
+	Remap              map[string]map[uint16]uint16 `mapstructure:"remap"`
+	Profiles           []ProfileConfig              `mapstructure:"profiles"`
+}
+
+// ProfileConfig 命名的配置方案，没有填写的项沿用顶层配置(即 default 方案)
+type ProfileConfig struct {
+	Name               string                     `mapstructure:"name"`
+	MouseConfigDict    map[string]map[byte]string `mapstructure:"mouseConfigDict"`
+	KeyboardConfigDict map[byte]string            `mapstructure:"keyboardConfigDict"`
+	Layers             []LayerConfig              `mapstructure:"layers"`
+	Sensitivity        *SensitivityConfig         `mapstructure:"sensitivity"`
+	Macros             []string                   `mapstructure:"macros"` //可以绑定的宏，支持通配符如 cad_*，为空时不限制
+}
+
+// DeviceOverride 强制指定匹配设备的类型，Name 和 ID 都填写时需同时满足
+type DeviceOverride struct {
+	Name string   `mapstructure:"name"` //设备名包含此字符串(不区分大小写)
+	ID   string   `mapstructure:"id"`   //"vendor:product"，如 "046d:c539"
+	Type []string `mapstructure:"type"` //mouse, keyboard, joystick, touch，或 none 表示不读取
+}
+
+// SensitivityConfig 指针灵敏度配置，devices 按设备名(小写)覆盖默认值
+type SensitivityConfig struct {
+	Default SensitivityProfile            `mapstructure:"default" json:"default"`
+	Devices map[string]SensitivityProfile `mapstructure:"devices" json:"devices"`
+}
+
+type SensitivityProfile struct {
+	X     *float64           `mapstructure:"x" json:"x"` //X轴倍率，未填写时为1，0表示禁用该轴
+	Y     *float64           `mapstructure:"y" json:"y"` //Y轴倍率
+	Curve string             `mapstructure:"curve" json:"curve"`
+	Power float64            `mapstructure:"power" json:"power,omitempty"` //power曲线的指数
+	Table []SensitivityPoint `mapstructure:"table" json:"table,omitempty"`
+}
+
+// SensitivityPoint table曲线上的一个点：单次报告移动速度为 Speed 时的增益
+type SensitivityPoint struct {
+	Speed float64 `mapstructure:"speed" json:"speed"`
+	Gain  float64 `mapstructure:"gain" json:"gain"`
+}
+
+// LayerConfig 按键层，后定义的层优先级更高，层中没有绑定的按键落到下面的层
+type LayerConfig struct {
+	Name     string          `mapstructure:"name" json:"name"`
+	Mouse    map[byte]string `mapstructure:"mouse" json:"mouse"`       //鼠标按键 -> 宏名或层切换，对所有鼠标生效
+	Keyboard map[byte]string `mapstructure:"keyboard" json:"keyboard"` //HID键码 -> 宏名或层切换
+}
+
+// TapHoldConfig th(单击, 长按) 绑定的判定方式
+type TapHoldConfig struct {
+	TappingTerm         int  `mapstructure:"tappingTerm"`         //按住超过此时间(毫秒)判定为长按，默认200
+	PermissiveHold      bool `mapstructure:"permissiveHold"`      //期间有其他按键完整按下并松开时判定为长按
+	HoldOnOtherKeyPress bool `mapstructure:"holdOnOtherKeyPress"` //期间有其他按键按下时立即判定为长按
+	TapDuration         int  `mapstructure:"tapDuration"`         //判定为单击时按住的时间(毫秒)，默认10
+}
+
+// GetTappingTerm 返回 tap-hold 的判定时间
+func GetTappingTerm() time.Duration {
+	if Cfg == nil || Cfg.TapHold.TappingTerm <= 0 {
+		return 200 * time.Millisecond
+	}
+	return time.Duration(Cfg.TapHold.TappingTerm) * time.Millisecond
+}
+
+// GetTapDuration 返回判定为单击后按下到松开的间隔
+func GetTapDuration() time.Duration {
+	if Cfg == nil || Cfg.TapHold.TapDuration <= 0 {
+		return 10 * time.Millisecond
+	}
+	return time.Duration(Cfg.TapHold.TapDuration) * time.Millisecond
+}
+
+// GetHoldOnOtherKeyPress 没有配置时为 false
+func GetHoldOnOtherKeyPress() bool {
+	return Cfg != nil && Cfg.TapHold.HoldOnOtherKeyPress
+}
+
+// GetPermissiveHold 没有配置时为 false
+func GetPermissiveHold() bool {
+	return Cfg != nil && Cfg.TapHold.PermissiveHold
+}
+
+// ComboConfig 组合键，Term 毫秒内按下组合中的全部按键时触发
+type ComboConfig struct {
+	Term   int     `mapstructure:"term"` //默认50毫秒
+	Combos []Combo `mapstructure:"combos"`
+}
+
+type Combo struct {
+	Keys   []string `mapstructure:"keys" json:"keys"`     //按键名，如 ["j", "k"]、["back", "forward"]
+	Action string   `mapstructure:"action" json:"action"` //宏名、层切换或按键名
+}
+
+// GetComboTerm 返回组合键的判定时间
+func GetComboTerm() time.Duration {
+	if Cfg == nil || Cfg.Combo.Term <= 0 {
+		return 50 * time.Millisecond
+	}
+	return time.Duration(Cfg.Combo.Term) * time.Millisecond
+}
+
+// LeaderConfig 按下 Key 后在 Timeout 毫秒内输入序列触发宏，期间的按键不转发
+type LeaderConfig struct {
+	Key       string           `mapstructure:"key"`     //按键名，为空时不启用
+	Timeout   int              `mapstructure:"timeout"` //默认1000毫秒
+	Sequences []LeaderSequence `mapstructure:"sequences"`
+}
+
+type LeaderSequence struct {
+	Keys  []string `mapstructure:"keys" json:"keys"` //按键名，如 ["g", "c"]
+	Macro string   `mapstructure:"macro" json:"macro"`
+}
+
+// GetLeaderTimeout 返回 leader 序列的超时时间
+func GetLeaderTimeout() time.Duration {
+	if Cfg == nil || Cfg.Leader.Timeout <= 0 {
+		return time.Second
+	}
+	return time.Duration(Cfg.Leader.Timeout) * time.Millisecond
+}
+
+// PluginConfig 外部插件，插件进程通过 Unix socket 连接并注册宏
+type PluginConfig struct {
+	Socket    string          `mapstructure:"socket"` //默认 /tmp/input2com.sock
+	Processes []PluginProcess `mapstructure:"processes"`
+}
+
+// PluginProcess 由 input2com 启动并在退出后自动重启的插件进程
+type PluginProcess struct {
+	Name    string   `mapstructure:"name"`
+	Command []string `mapstructure:"command"` //程序和参数
+	Dir     string   `mapstructure:"dir"`     //工作目录，默认当前目录
+}
+
+// GetPluginSocket 返回插件 socket 路径
+func GetPluginSocket() string {
+	if Cfg == nil || Cfg.Plugins.Socket == "" {
+		return "/tmp/input2com.sock"
+	}
+	return Cfg.Plugins.Socket
+}
+
+// ScriptConfig 脚本宏(Starlark)的目录和资源限制
+type ScriptConfig struct {
+	Dir        string `mapstructure:"dir"`        //默认 scripts
+	MaxSteps   uint64 `mapstructure:"maxSteps"`   //单次调用(on_press/on_release)最多执行的步数，超出后终止脚本，默认 1000000
+	MaxRunTime int    `mapstructure:"maxRunTime"` //单次调用最长运行时间(毫秒)，超出后终止脚本，默认 600000(10分钟)
+}
+
+// GetScriptLimits 返回脚本目录、步数限制和运行时间限制
+func GetScriptLimits() (string, uint64, time.Duration) {
+	dir, steps, runTime := "scripts", uint64(1000000), 10*time.Minute
+	if Cfg != nil {
+		if Cfg.Scripts.Dir != "" {
+			dir = Cfg.Scripts.Dir
+		}
+		if Cfg.Scripts.MaxSteps > 0 {
+			steps = Cfg.Scripts.MaxSteps
+		}
+		if Cfg.Scripts.MaxRunTime > 0 {
+			runTime = time.Duration(Cfg.Scripts.MaxRunTime) * time.Millisecond
+		}
+	}
+	return dir, steps, runTime
+}
+
+// TyperConfig 输入文本时使用的目标端键盘布局和每个按键的间隔
+type TyperConfig struct {
+	Layout string `mapstructure:"layout"` //us, uk, de, fr, jp
+	Delay  int    `mapstructure:"delay"`  //毫秒
+}
+
+// KeyRepeatConfig 按键自动重复(evdev value==2)的处理方式
+type KeyRepeatConfig struct {
+	KeyRepeatRule `mapstructure:",squash"`
+	Keys          map[byte]KeyRepeatRule `mapstructure:"keys"` //按HID键码单独配置
 }
 
+type KeyRepeatRule struct {
+	Mode  string `mapstructure:"mode"`  //ignore 忽略, passthrough 透传, software 软件生成
+	Delay int    `mapstructure:"delay"` //software模式下首次重复前的延迟(毫秒)
+	Rate  int    `mapstructure:"rate"`  //software模式下每秒重复次数
+}
+
+const (
+	KeyRepeatIgnore      = "ignore"
+	KeyRepeatPassthrough = "passthrough"
+	KeyRepeatSoftware    = "software"
+)
+
 var Cfg *Config
 
 func GetTriggerDelay() int64 {
@@ -28,6 +232,79 @@ func GetAimDelay() int32 {
 func GetAimSpeed() int {
 	return Cfg.AimSpeed
 }
+
+// GetMacrosDir 声明式宏所在目录，默认 macros
+func GetMacrosDir() string {
+	if Cfg == nil || Cfg.MacrosDir == "" {
+		return "macros"
+	}
+	return Cfg.MacrosDir
+}
+
+// GetTyper 返回默认键盘布局和按键间隔，默认 us 布局、10毫秒
+func GetTyper() (string, time.Duration) {
+	layout, delay := "us", 10
+	if Cfg != nil {
+		if Cfg.Typer.Layout != "" {
+			layout = Cfg.Typer.Layout
+		}
+		if Cfg.Typer.Delay > 0 {
+			delay = Cfg.Typer.Delay
+		}
+	}
+	return layout, time.Duration(delay) * time.Millisecond
+}
+
+// GetKeyRepeat 返回某个HID键码的重复模式、首次延迟和重复间隔，未配置的项使用全局值，都没有配置时为 ignore
+func GetKeyRepeat(keyCode byte) (string, time.Duration, time.Duration) {
+	var cfg KeyRepeatConfig
+	if Cfg != nil {
+		cfg = Cfg.KeyRepeat
+	}
+	rule := cfg.KeyRepeatRule
+	if keyRule, ok := cfg.Keys[keyCode]; ok {
+		if keyRule.Mode != "" {
+			rule.Mode = keyRule.Mode
+		}
+		if keyRule.Delay > 0 {
+			rule.Delay = keyRule.Delay
+		}
+		if keyRule.Rate > 0 {
+			rule.Rate = keyRule.Rate
+		}
+	}
+	if rule.Mode == "" {
+		rule.Mode = KeyRepeatIgnore
+	}
+	if rule.Delay <= 0 {
+		rule.Delay = 500
+	}
+	if rule.Rate <= 0 {
+		rule.Rate = 30
+	}
+	return rule.Mode, time.Duration(rule.Delay) * time.Millisecond, time.Second / time.Duration(rule.Rate)
+}
+
+// Validate 检查重复模式，空字符串使用默认值
+func (c KeyRepeatConfig) Validate() error {
+	check := func(mode string) error {
+		switch mode {
+		case "", KeyRepeatIgnore, KeyRepeatPassthrough, KeyRepeatSoftware:
+			return nil
+		}
+		return fmt.Errorf("unknown keyRepeat mode %q (ignore, passthrough, software)", mode)
+	}
+	if err := check(c.Mode); err != nil {
+		return err
+	}
+	for code, rule := range c.Keys {
+		if err := check(rule.Mode); err != nil {
+			return fmt.Errorf("keyRepeat.keys.%d: %w", code, err)
+		}
+	}
+	return nil
+}
+
 func InitConfig() {
 	viper.SetConfigName("config")
 	viper.SetConfigType("yaml")
@@ -40,4 +317,7 @@ func InitConfig() {
 	if err != nil {
 		panic(err)
 	}
+	if err = Cfg.KeyRepeat.Validate(); err != nil {
+		panic(err)
+	}
 }
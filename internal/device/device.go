@@ -0,0 +1,318 @@
+package device
+
+import (
+	"fmt"
+	"input2com/internal/config"
+	"input2com/internal/logger"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/kenshaw/evdev"
+)
+
+// VirtualDeviceName 程序自己生成的虚拟设备，永远不读取
+const VirtualDeviceName = "input2com-virtual-device"
+
+// Type 设备类型，一个设备可以同时属于多种类型(例如键鼠共用接口的接收器)
+type Type uint8
+
+const (
+	TypeMouse Type = 1 << iota
+	TypeKeyboard
+	TypeJoystick
+	TypeTouch
+	TypeUnknown = Type(0)
+)
+
+var typeFriendlyName = []struct {
+	t    Type
+	name string
+	key  string
+}{
+	{TypeMouse, "鼠标", "mouse"},
+	{TypeKeyboard, "键盘", "keyboard"},
+	{TypeJoystick, "手柄", "joystick"},
+	{TypeTouch, "触屏", "touch"},
+}
+
+func (t Type) Has(o Type) bool {
+	return t&o != 0
+}
+
+func (t Type) String() string {
+	names := make([]string, 0)
+	for _, n := range typeFriendlyName {
+		if t.Has(n.t) {
+			names = append(names, n.name)
+		}
+	}
+	if len(names) == 0 {
+		return "未知"
+	}
+	return strings.Join(names, "+")
+}
+
+func (t Type) MarshalText() ([]byte, error) {
+	names := make([]string, 0)
+	for _, n := range typeFriendlyName {
+		if t.Has(n.t) {
+			names = append(names, n.key)
+		}
+	}
+	return []byte(strings.Join(names, ",")), nil
+}
+
+// ParseType 解析配置中的类型名称列表，"none" 表示不作为任何类型
+func ParseType(names []string) (Type, error) {
+	t := TypeUnknown
+	for _, name := range names {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "none" {
+			continue
+		}
+		found := false
+		for _, n := range typeFriendlyName {
+			if n.key == name {
+				t |= n.t
+				found = true
+			}
+		}
+		if !found {
+			return TypeUnknown, fmt.Errorf("unknown device type %q", name)
+		}
+	}
+	return t, nil
+}
+
+// 分类阈值
+const (
+	mouseThreshold    = 4  // 相对XY 2分 + 左键 2分
+	keyboardThreshold = 20 // 字母数字各1分，常用功能键各2分
+	macroPadKeys      = 8  // 没有其他轴的设备，键盘区按键数量达到此值也视为键盘(小键盘、宏键盘)
+)
+
+var keyboardCoreKeys = []evdev.KeyType{
+	evdev.KeyEnter, evdev.KeySpace, evdev.KeyEscape, evdev.KeyBackSpace, evdev.KeyTab,
+}
+
+// Classify 根据设备能力打分判断设备类型
+func Classify(dev *evdev.Evdev) Type {
+	abs := dev.AbsoluteTypes()
+	key := dev.KeyTypes()
+	rel := dev.RelativeTypes()
+	t := TypeUnknown
+
+	_, MTPositionX := abs[evdev.AbsoluteMTPositionX]
+	_, MTPositionY := abs[evdev.AbsoluteMTPositionY]
+	_, MTSlot := abs[evdev.AbsoluteMTSlot]
+	_, MTTrackingID := abs[evdev.AbsoluteMTTrackingID]
+	if MTPositionX && MTPositionY && MTSlot && MTTrackingID {
+		t |= TypeTouch //触屏检测这几个abs类型即可
+	}
+
+	mouseScore := 0
+	if rel[evdev.RelativeX] && rel[evdev.RelativeY] {
+		mouseScore += 2
+	}
+	if key[evdev.BtnLeft] {
+		mouseScore += 2
+	}
+	for _, ok := range []bool{key[evdev.BtnRight], key[evdev.BtnMiddle], rel[evdev.RelativeWheel]} {
+		if ok {
+			mouseScore++
+		}
+	}
+	if mouseScore >= mouseThreshold {
+		t |= TypeMouse //鼠标 不再要求横向滚轮
+	}
+
+	keyboardScore := 0
+	for _, row := range [][2]evdev.KeyType{{evdev.KeyQ, evdev.KeyP}, {evdev.KeyA, evdev.KeyL}, {evdev.KeyZ, evdev.KeyM}} {
+		for i := row[0]; i <= row[1]; i++ {
+			if key[i] {
+				keyboardScore++ //字母区三行
+			}
+		}
+	}
+	for i := evdev.Key1; i <= evdev.Key0; i++ {
+		if key[i] {
+			keyboardScore++
+		}
+	}
+	for _, k := range keyboardCoreKeys {
+		if key[k] {
+			keyboardScore += 2
+		}
+	}
+	keypadKeys := 0
+	for k := range key {
+		if k > evdev.KeyReserved && k < evdev.BtnMisc {
+			keypadKeys++
+		}
+	}
+	if keyboardScore >= keyboardThreshold ||
+		(keypadKeys >= macroPadKeys && len(rel) == 0 && len(abs) == 0) {
+		t |= TypeKeyboard
+	}
+
+	axisCount := 0
+	for i := evdev.AbsoluteX; i <= evdev.AbsoluteRZ; i++ {
+		_, ok := abs[i]
+		if ok {
+			axisCount++
+		}
+	}
+	keyCount := 0
+	for i := evdev.BtnA; i <= evdev.BtnZ; i++ {
+		if key[i] {
+			keyCount++
+		}
+	}
+	if axisCount >= 4 && keyCount >= 4 {
+		t |= TypeJoystick //手柄 检测LS,RS A,B,X,Y
+	}
+	return t
+}
+
+// Info 设备信息
+type Info struct {
+	Index        int      `json:"index"`
+	Path         string   `json:"path"`
+	Name         string   `json:"name"`
+	ID           evdev.ID `json:"id"`
+	Phys         string   `json:"phys"`
+	Type         Type     `json:"type"`
+	Capabilities string   `json:"capabilities"`
+	Grab         bool     `json:"grab"`  //input2com 是否会独占读取该设备
+	Rules        []string `json:"rules"` //匹配到的配置项
+}
+
+// Path 返回设备序号对应的设备节点
+func Path(index int) string {
+	return fmt.Sprintf("/dev/input/event%d", index)
+}
+
+// Probe 打开设备读取信息后关闭
+func Probe(index int) (*Info, error) {
+	fd, err := os.OpenFile(Path(index), os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	d := evdev.Open(fd)
+	defer d.Close()
+	info := &Info{
+		Index:        index,
+		Path:         Path(index),
+		Name:         d.Name(),
+		ID:           d.ID(),
+		Phys:         d.Path(),
+		Type:         Classify(d),
+		Capabilities: capabilities(d),
+	}
+	info.Grab, info.Rules = match(info)
+	return info, nil
+}
+
+// capabilities 生成设备能力摘要
+func capabilities(d *evdev.Evdev) string {
+	parts := make([]string, 0)
+	if rel := d.RelativeTypes(); len(rel) > 0 {
+		names := make([]string, 0, len(rel))
+		for r := range rel {
+			names = append(names, strings.TrimPrefix(r.String(), "Relative"))
+		}
+		sort.Strings(names)
+		parts = append(parts, "rel:"+strings.Join(names, ","))
+	}
+	keys, btns := 0, 0
+	for k := range d.KeyTypes() {
+		if k >= evdev.BtnMisc && k < evdev.KeyOk {
+			btns++
+		} else {
+			keys++
+		}
+	}
+	if keys > 0 {
+		parts = append(parts, fmt.Sprintf("keys:%d", keys))
+	}
+	if btns > 0 {
+		parts = append(parts, fmt.Sprintf("buttons:%d", btns))
+	}
+	if abs := d.AbsoluteTypes(); len(abs) > 0 {
+		parts = append(parts, fmt.Sprintf("abs:%d", len(abs)))
+	}
+	return strings.Join(parts, " ")
+}
+
+// match 判断是否会读取该设备，并列出匹配到的配置项
+func match(info *Info) (bool, []string) {
+	rules := make([]string, 0)
+	name := strings.ToLower(info.Name)
+	if config.Cfg == nil {
+		return info.Name != VirtualDeviceName && info.Type.Has(TypeMouse|TypeKeyboard|TypeJoystick), rules
+	}
+	for i, override := range config.Cfg.DeviceOverrides {
+		if !overrideMatches(override, info) {
+			continue
+		}
+		t, err := ParseType(override.Type)
+		if err != nil {
+			logger.Logger.Warnf("deviceOverrides[%d] 配置错误: %v", i, err)
+			continue
+		}
+		info.Type = t
+		rules = append(rules, fmt.Sprintf("deviceOverrides[%d]", i))
+		break
+	}
+	grab := info.Name != VirtualDeviceName && info.Type.Has(TypeMouse|TypeKeyboard|TypeJoystick)
+	for _, ignore := range config.Cfg.IgnoreDevices {
+		if strings.ToLower(ignore) == name {
+			rules = append(rules, "ignoreDevices")
+			grab = false
+		}
+	}
+	if _, ok := config.Cfg.MouseConfigDict[name]; ok {
+		rules = append(rules, "mouseConfigDict."+name)
+	}
+	if _, ok := config.Cfg.Sensitivity.Devices[name]; ok {
+		rules = append(rules, "sensitivity.devices."+name)
+	}
+	if _, ok := config.Cfg.Remap[name]; ok {
+		rules = append(rules, "remap."+name)
+	}
+	return grab, rules
+}
+
+// overrideMatches 设备名按包含关系(不区分大小写)匹配，ID 为 "vendor:product" 十六进制
+func overrideMatches(override config.DeviceOverride, info *Info) bool {
+	if override.Name == "" && override.ID == "" {
+		return false
+	}
+	if override.Name != "" && !strings.Contains(strings.ToLower(info.Name), strings.ToLower(override.Name)) {
+		return false
+	}
+	if override.ID != "" && !strings.EqualFold(override.ID, fmt.Sprintf("%04x:%04x", info.ID.Vendor, info.ID.Product)) {
+		return false
+	}
+	return true
+}
+
+// Indexes 返回 /dev/input 下所有 event 设备的序号
+func Indexes() []int {
+	files, _ := os.ReadDir("/dev/input")
+	result := make([]int, 0)
+	for _, file := range files {
+		if file.IsDir() || !strings.HasPrefix(file.Name(), "event") {
+			continue
+		}
+		index, err := strconv.Atoi(strings.TrimPrefix(file.Name(), "event"))
+		if err != nil {
+			continue
+		}
+		result = append(result, index)
+	}
+	sort.Ints(result)
+	return result
+}
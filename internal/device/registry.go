@@ -0,0 +1,218 @@
+package device
+
+import (
+	"context"
+	"input2com/internal/logger"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/kenshaw/evdev"
+)
+
+type EventKind int
+
+const (
+	EventAdded EventKind = iota
+	EventRemoved
+)
+
+func (k EventKind) String() string {
+	if k == EventAdded {
+		return "added"
+	}
+	return "removed"
+}
+
+// Event 设备插入/移除事件
+type Event struct {
+	Kind   EventKind
+	Device Info
+}
+
+// Device 已打开设备的状态
+type Device struct {
+	Info       Info      `json:"info"`
+	Opened     time.Time `json:"opened"`
+	Reports    uint64    `json:"reports"`    //已读取的报告数
+	LastReport time.Time `json:"lastReport"` //最后一个报告的内核时间戳
+}
+
+// Handle 由 Registry 打开并独占的设备，读取结束后调用 Close 归还
+type Handle struct {
+	Info     Info
+	registry *Registry
+	dev      *evdev.Evdev
+}
+
+// Poll 读取设备事件，设备被关闭或拔出时管道关闭
+func (h *Handle) Poll(ctx context.Context) <-chan *evdev.EventEnvelope {
+	return h.dev.Poll(ctx)
+}
+
+// Observe 记录读取到的一个报告
+func (h *Handle) Observe(t time.Time) {
+	h.registry.mutex.Lock()
+	defer h.registry.mutex.Unlock()
+	if dev, ok := h.registry.devices[h.Info.Index]; ok && dev.handle == h {
+		dev.Reports++
+		dev.LastReport = t
+	}
+}
+
+// Close 释放并关闭设备，广播移除事件，可以重复调用
+func (h *Handle) Close() {
+	h.registry.close(h)
+}
+
+type openDevice struct {
+	Device
+	handle *Handle
+}
+
+// Registry 打开、独占和关闭设备，记录每个设备的状态，并向订阅者广播插入/移除事件
+type Registry struct {
+	devices     map[int]*openDevice
+	subscribers []*subscriber
+	mutex       sync.RWMutex
+}
+
+// subscriber 每个订阅者有自己的队列，处理慢的订阅者不会让事件丢失，也不会阻塞打开和关闭设备
+type subscriber struct {
+	mutex  sync.Mutex
+	queue  []Event
+	notify chan struct{} // 队列有新事件
+}
+
+func NewRegistry() *Registry {
+	return &Registry{devices: make(map[int]*openDevice)}
+}
+
+// Devices 全局设备表
+var Devices = NewRegistry()
+
+// Open 打开并独占设备，登记后广播插入事件。设备已经打开时返回 nil, nil
+func (r *Registry) Open(info *Info) (*Handle, error) {
+	r.mutex.Lock()
+	if _, ok := r.devices[info.Index]; ok {
+		r.mutex.Unlock()
+		return nil, nil
+	}
+	fd, err := os.OpenFile(Path(info.Index), os.O_RDONLY, 0)
+	if err != nil {
+		r.mutex.Unlock()
+		return nil, err
+	}
+	d := evdev.Open(fd)
+	if err := d.Lock(); err != nil {
+		logger.Logger.Warnf("无法独占设备 %s，事件仍会被系统收到: %v", info.Path, err)
+	}
+	h := &Handle{Info: *info, registry: r, dev: d}
+	r.devices[info.Index] = &openDevice{Device: Device{Info: *info, Opened: time.Now()}, handle: h}
+	r.mutex.Unlock()
+	r.publish(Event{Kind: EventAdded, Device: *info})
+	return h, nil
+}
+
+func (r *Registry) close(h *Handle) {
+	r.mutex.Lock()
+	dev, ok := r.devices[h.Info.Index]
+	if !ok || dev.handle != h {
+		r.mutex.Unlock()
+		return
+	}
+	delete(r.devices, h.Info.Index)
+	r.mutex.Unlock()
+	h.dev.Close() //Close 会先解除独占
+	r.publish(Event{Kind: EventRemoved, Device: h.Info})
+}
+
+// CloseAll 关闭所有设备，程序退出时调用
+func (r *Registry) CloseAll() {
+	r.mutex.RLock()
+	handles := make([]*Handle, 0, len(r.devices))
+	for _, dev := range r.devices {
+		handles = append(handles, dev.handle)
+	}
+	r.mutex.RUnlock()
+	for _, h := range handles {
+		h.Close()
+	}
+}
+
+func (r *Registry) IsOpen(index int) bool {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	_, ok := r.devices[index]
+	return ok
+}
+
+// List 按设备序号返回所有已打开的设备
+func (r *Registry) List() []Device {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	result := make([]Device, 0, len(r.devices))
+	for _, dev := range r.devices {
+		result = append(result, dev.Device)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Info.Index < result[j].Info.Index })
+	return result
+}
+
+// Subscribe 订阅设备事件，事件按发生的顺序全部送达。ctx 结束时取消订阅并关闭管道
+func (r *Registry) Subscribe(ctx context.Context) <-chan Event {
+	sub := &subscriber{notify: make(chan struct{}, 1)}
+	r.mutex.Lock()
+	r.subscribers = append(r.subscribers, sub)
+	r.mutex.Unlock()
+	out := make(chan Event)
+	go func() {
+		defer close(out)
+		defer r.unsubscribe(sub)
+		for {
+			sub.mutex.Lock()
+			queue := sub.queue
+			sub.queue = nil
+			sub.mutex.Unlock()
+			for _, e := range queue {
+				select {
+				case out <- e:
+				case <-ctx.Done():
+					return
+				}
+			}
+			select {
+			case <-sub.notify:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
+
+func (r *Registry) unsubscribe(sub *subscriber) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for i, s := range r.subscribers {
+		if s == sub {
+			r.subscribers = append(r.subscribers[:i], r.subscribers[i+1:]...)
+			return
+		}
+	}
+}
+
+func (r *Registry) publish(e Event) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	for _, sub := range r.subscribers {
+		sub.mutex.Lock()
+		sub.queue = append(sub.queue, e)
+		sub.mutex.Unlock()
+		select {
+		case sub.notify <- struct{}{}:
+		default: // 已经有未处理的通知
+		}
+	}
+}
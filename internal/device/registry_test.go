@@ -0,0 +1,37 @@
+package device
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestSubscribeDeliversEveryEvent(t *testing.T) {
+	r := NewRegistry()
+	ctx, cancel := context.WithCancel(context.Background())
+	events := r.Subscribe(ctx)
+	const n = 100 // 远多于旧的 16 个缓冲
+	for i := 0; i < n; i++ {
+		r.publish(Event{Kind: EventKind(i % 2), Device: Info{Name: fmt.Sprint(i)}})
+	}
+	for i := 0; i < n; i++ {
+		select {
+		case e := <-events:
+			if e.Device.Name != fmt.Sprint(i) {
+				t.Fatalf("event %d: got %s, want events in order", i, e.Device.Name)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("event %d was dropped", i)
+		}
+	}
+
+	cancel()
+	for range events { // 取消后管道关闭
+	}
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	if len(r.subscribers) != 0 {
+		t.Errorf("subscriber not removed after cancel")
+	}
+}
@@ -19,6 +19,11 @@ const (
 	MouseBtnMiddle  = byte(1 << 2) // 中键
 	MouseBtnBack    = byte(1 << 3) // 后退键
 	MouseBtnForward = byte(1 << 4) // 前进键
+	MouseBtnExtFwd  = byte(1 << 5) // BTN_FORWARD
+	MouseBtnExtBack = byte(1 << 6) // BTN_BACK
+	MouseBtnTask    = byte(1 << 7) // BTN_TASK
+	// BTN_MOUSE+8 起的按键(MMO鼠标侧键)没有对应的位，使用不会与位掩码冲突的编号 0x90~0x97
+	MouseBtnExtBase = byte(0x90)
 
 	KeyLeftCtrl   = byte(0xe0)
 	KeyLeftShift  = byte(0xe1)
@@ -281,14 +286,42 @@ var Linux2hid = map[uint16]uint8{
 	126: 232,
 }
 
-var MouseValidKeys = map[string]bool{
-	strconv.FormatUint(uint64(MouseBtnLeft), 10):    true,
-	strconv.FormatUint(uint64(MouseBtnRight), 10):   true,
-	strconv.FormatUint(uint64(MouseBtnMiddle), 10):  true,
-	strconv.FormatUint(uint64(MouseBtnBack), 10):    true,
-	strconv.FormatUint(uint64(MouseBtnForward), 10): true,
+// evdev 鼠标按键码 -> 鼠标按键编号，覆盖 BTN_MOUSE(0x110) ~ 0x11f 全部按键
+var EvdevMouseBtns = map[uint16]byte{
+	0x110: MouseBtnLeft,        // BTN_LEFT
+	0x111: MouseBtnRight,       // BTN_RIGHT
+	0x112: MouseBtnMiddle,      // BTN_MIDDLE
+	0x113: MouseBtnBack,        // BTN_SIDE
+	0x114: MouseBtnForward,     // BTN_EXTRA
+	0x115: MouseBtnExtFwd,      // BTN_FORWARD
+	0x116: MouseBtnExtBack,     // BTN_BACK
+	0x117: MouseBtnTask,        // BTN_TASK
+	0x118: MouseBtnExtBase + 0, // 0x118 ~ 0x11f 无标准名称
+	0x119: MouseBtnExtBase + 1,
+	0x11a: MouseBtnExtBase + 2,
+	0x11b: MouseBtnExtBase + 3,
+	0x11c: MouseBtnExtBase + 4,
+	0x11d: MouseBtnExtBase + 5,
+	0x11e: MouseBtnExtBase + 6,
+	0x11f: MouseBtnExtBase + 7,
 }
 
+// HIDMouseBtns 标准鼠标报告中的五个按键
+const HIDMouseBtns = MouseBtnLeft | MouseBtnRight | MouseBtnMiddle | MouseBtnBack | MouseBtnForward
+
+// MouseBtnInMask 判断按键是否在控制器支持的按键位掩码中，0x90 起的扩展按键没有对应的位，不在任何掩码中
+func MouseBtnInMask(mask, keyCode byte) bool {
+	return keyCode != 0 && keyCode&(keyCode-1) == 0 && mask&keyCode != 0
+}
+
+var MouseValidKeys = func() map[string]bool {
+	keys := make(map[string]bool)
+	for _, btn := range EvdevMouseBtns {
+		keys[strconv.FormatUint(uint64(btn), 10)] = true
+	}
+	return keys
+}()
+
 var KeyboardValidKeys = map[string]bool{
 	strconv.FormatUint(uint64(KeyLeftCtrl), 10):    true,
 	strconv.FormatUint(uint64(KeyLeftShift), 10):   true,
@@ -0,0 +1,147 @@
+package input
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ISO/JIS 键盘上额外的按键
+const (
+	KeyNonUsBackslash = byte(0x64) // ISO 左Shift右边的按键
+	KeyRo             = byte(0x87) // JIS ろ 键(International1)
+	KeyYen            = byte(0x89) // JIS ¥ 键(International3)
+)
+
+// Stroke 输入一个字符需要的按键，Modifiers 在 Key 之前按下、之后松开
+type Stroke struct {
+	Key       byte   `json:"key"`
+	Modifiers []byte `json:"modifiers,omitempty"`
+}
+
+// Layout 目标端的键盘布局，字符 -> 按键序列(死键需要两次按键)
+type Layout map[rune][]Stroke
+
+// layoutKeys 布局表中字符的顺序，对应 US 键盘上的 `1234567890-=qwertyuiop[]\asdfghjkl;'#<zxcvbnm,./ 以及 JIS 的 ろ ¥
+var layoutKeys = func() []byte {
+	keys := []byte{KeyBackquote, Key1, Key2, Key3, Key4, Key5, Key6, Key7, Key8, Key9, Key0, KeyMinus, KeyEqual}
+	for _, c := range "qwertyuiop" {
+		keys = append(keys, KeyA+byte(c-'a'))
+	}
+	keys = append(keys, KeyLbracket, KeyRbracket, KeyBackslash)
+	for _, c := range "asdfghjkl" {
+		keys = append(keys, KeyA+byte(c-'a'))
+	}
+	keys = append(keys, KeySemicolon, KeyQuote, KeyHash, KeyNonUsBackslash)
+	for _, c := range "zxcvbnm" {
+		keys = append(keys, KeyA+byte(c-'a'))
+	}
+	return append(keys, KeyComma, KeyPeriod, KeySlash, KeyRo, KeyYen)
+}()
+
+// layoutDef 布局定义，normal/shift 按 layoutKeys 的顺序排列，空格表示该位置没有字符
+type layoutDef struct {
+	normal string
+	shift  string
+	altGr  map[rune]byte   // AltGr + 按键
+	dead   map[rune]Stroke // 死键，输入时后面跟一个空格
+}
+
+var layoutDefs = map[string]layoutDef{
+	"us": {
+		normal: "`1234567890-=qwertyuiop[]\\asdfghjkl;'  zxcvbnm,./  ",
+		shift:  "~!@#$%^&*()_+QWERTYUIOP{}|ASDFGHJKL:\"  ZXCVBNM<>?  ",
+	},
+	"uk": {
+		normal: "`1234567890-=qwertyuiop[] asdfghjkl;'#\\zxcvbnm,./  ",
+		shift:  "¬!\"£$%^&*()_+QWERTYUIOP{} ASDFGHJKL:@~|ZXCVBNM<>?  ",
+		altGr:  map[rune]byte{'¦': KeyBackquote, '€': Key4, 'á': KeyA, 'é': KeyE, 'í': KeyI, 'ó': KeyO, 'ú': KeyU},
+	},
+	"de": {
+		normal: " 1234567890ß qwertzuiopü+ asdfghjklöä#<yxcvbnm,.-  ",
+		shift:  "°!\"§$%&/()=? QWERTZUIOPÜ* ASDFGHJKLÖÄ'>YXCVBNM;:_  ",
+		altGr: map[rune]byte{'²': Key2, '³': Key3, '{': Key7, '[': Key8, ']': Key9, '}': Key0, '\\': KeyMinus,
+			'@': KeyQ, '€': KeyE, '~': KeyRbracket, '|': KeyNonUsBackslash, 'µ': KeyM},
+		dead: map[rune]Stroke{'^': {Key: KeyBackquote}, '´': {Key: KeyEqual}, '`': {Key: KeyEqual, Modifiers: []byte{KeyLeftShift}}},
+	},
+	"fr": {
+		normal: "²&é\"'(-è_çà)=azertyuiop $ qsdfghjklmù*<wxcvbn,;:!  ",
+		shift:  " 1234567890°+AZERTYUIOP £ QSDFGHJKLM%µ>WXCVBN?./§  ",
+		altGr: map[rune]byte{'#': Key3, '{': Key4, '[': Key5, '|': Key6, '\\': Key8, '^': Key9, '@': Key0,
+			']': KeyMinus, '}': KeyEqual, '€': KeyE, '¤': KeyRbracket},
+		dead: map[rune]Stroke{'¨': {Key: KeyLbracket, Modifiers: []byte{KeyLeftShift}},
+			'~': {Key: Key2, Modifiers: []byte{KeyRightAlt}}, '`': {Key: Key7, Modifiers: []byte{KeyRightAlt}}},
+	},
+	"jp": {
+		normal: " 1234567890-^qwertyuiop@[ asdfghjkl;:] zxcvbnm,./\\¥",
+		shift:  " !\"#$%&'() =~QWERTYUIOP`{ ASDFGHJKL+*} ZXCVBNM<>?_|",
+	},
+}
+
+// Layouts 支持的键盘布局
+var Layouts = func() map[string]Layout {
+	layouts := make(map[string]Layout)
+	for name, def := range layoutDefs {
+		layout := Layout{
+			' ':  {{Key: KeySpace}},
+			'\n': {{Key: KeyEnter}},
+			'\t': {{Key: KeyTab}},
+		}
+		add := func(table string, modifiers []byte) {
+			for i, r := range []rune(table) {
+				if _, exists := layout[r]; r != ' ' && !exists {
+					layout[r] = []Stroke{{Key: layoutKeys[i], Modifiers: modifiers}}
+				}
+			}
+		}
+		add(def.normal, nil)
+		add(def.shift, []byte{KeyLeftShift})
+		for r, key := range def.altGr {
+			layout[r] = []Stroke{{Key: key, Modifiers: []byte{KeyRightAlt}}}
+		}
+		for r, stroke := range def.dead {
+			if _, exists := layout[r]; !exists {
+				layout[r] = []Stroke{stroke, {Key: KeySpace}}
+			}
+		}
+		layouts[name] = layout
+	}
+	return layouts
+}()
+
+// LayoutNames 返回支持的布局名称
+func LayoutNames() []string {
+	names := make([]string, 0, len(Layouts))
+	for name := range Layouts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Strokes 将文本转换为按键序列，所有无法输入的字符会一起报告
+func (l Layout) Strokes(text string) ([]Stroke, error) {
+	strokes := make([]Stroke, 0, len(text))
+	var invalid []string
+	for i, r := range []rune(text) {
+		s, ok := l[r]
+		if !ok {
+			invalid = append(invalid, fmt.Sprintf("%q at %d", r, i))
+			continue
+		}
+		strokes = append(strokes, s...)
+	}
+	if len(invalid) > 0 {
+		return nil, fmt.Errorf("characters not representable in layout: %s", strings.Join(invalid, ", "))
+	}
+	return strokes, nil
+}
+
+// GetLayout 按名称获取布局，名称不区分大小写
+func GetLayout(name string) (Layout, error) {
+	layout, ok := Layouts[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("unknown keyboard layout %q, supported: %s", name, strings.Join(LayoutNames(), ", "))
+	}
+	return layout, nil
+}
@@ -0,0 +1,102 @@
+package input
+
+import (
+	"strconv"
+	"strings"
+)
+
+// MouseBtnNames 鼠标按键名称，用于宏定义和配置
+var MouseBtnNames = map[string]byte{
+	"left":    MouseBtnLeft,
+	"right":   MouseBtnRight,
+	"middle":  MouseBtnMiddle,
+	"back":    MouseBtnBack,
+	"side":    MouseBtnBack,
+	"forward": MouseBtnForward,
+	"extra":   MouseBtnForward,
+}
+
+// KeyNames 键盘按键名称 -> HID键码，名称不区分大小写
+var KeyNames = func() map[string]byte {
+	names := map[string]byte{
+		"enter": KeyEnter, "return": KeyEnter, "esc": KeyEsc, "escape": KeyEsc,
+		"backspace": KeyBackspace, "tab": KeyTab, "space": KeySpace,
+		"minus": KeyMinus, "equal": KeyEqual, "lbracket": KeyLbracket, "rbracket": KeyRbracket,
+		"backslash": KeyBackslash, "hash": KeyHash, "semicolon": KeySemicolon, "quote": KeyQuote,
+		"backquote": KeyBackquote, "grave": KeyBackquote, "comma": KeyComma, "period": KeyPeriod,
+		"slash": KeySlash, "capslock": KeyCapsLock, "print": KeyPrint, "scrolllock": KeyScrollLock,
+		"pause": KeyPause, "insert": KeyInsert, "home": KeyHome, "pageup": KeyPageup,
+		"delete": KeyDelete, "del": KeyDelete, "end": KeyEnd, "pagedown": KeyPagedown,
+		"right": KeyRight, "left": KeyLeft, "down": KeyDown, "up": KeyUp, "numlock": KeyNumLock,
+		"kpdivide": KeyKpDivide, "kpmultiply": KeyKpMultiply, "kpminus": KeyKpMinus,
+		"kpplus": KeyKpPlus, "kpenter": KeyKpEnter, "kpperiod": KeyKpPeriod, "kpequal": KeyKpEqual,
+		"application": KeyApplication, "menu": KeyMenu, "mute": KeyMute,
+		"volumeup": KeyVolumeUp, "volumedown": KeyVolumeDown,
+		"ctrl": KeyLeftCtrl, "lctrl": KeyLeftCtrl, "shift": KeyLeftShift, "lshift": KeyLeftShift,
+		"alt": KeyLeftAlt, "lalt": KeyLeftAlt, "gui": KeyLeftGui, "lgui": KeyLeftGui, "win": KeyLeftGui,
+		"rctrl": KeyRightCtrl, "rshift": KeyRightShift, "ralt": KeyRightAlt, "altgr": KeyRightAlt,
+		"rgui": KeyRightGui,
+	}
+	for i := byte(0); i < 26; i++ {
+		names[string(rune('a'+i))] = KeyA + i
+	}
+	for i := byte(1); i <= 9; i++ {
+		names[strconv.Itoa(int(i))] = Key1 + i - 1
+		names["kp"+strconv.Itoa(int(i))] = KeyKp1 + i - 1
+	}
+	names["0"] = Key0
+	names["kp0"] = KeyKp0
+	for i := byte(0); i < 12; i++ {
+		names["f"+strconv.Itoa(int(i)+1)] = KeyF1 + i
+		names["f"+strconv.Itoa(int(i)+13)] = KeyF13 + i
+	}
+	return names
+}()
+
+// ParseKey 解析按键名称，mouse: 前缀或鼠标按键名表示鼠标按键，key: 前缀或其他名称表示键盘按键，
+// 也可以直接写HID键码数字(键盘)
+func ParseKey(name string) (code byte, isMouse bool, ok bool) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	switch {
+	case strings.HasPrefix(name, "mouse:"):
+		code, ok = MouseBtnNames[strings.TrimPrefix(name, "mouse:")]
+		return code, true, ok
+	case strings.HasPrefix(name, "key:"):
+		name = strings.TrimPrefix(name, "key:")
+	default:
+		if code, ok = MouseBtnNames[name]; ok {
+			return code, true, true
+		}
+	}
+	if code, ok = KeyNames[name]; ok {
+		return code, false, true
+	}
+	if n, err := strconv.ParseUint(name, 10, 8); err == nil {
+		return byte(n), false, true
+	}
+	return 0, false, false
+}
+
+// KeyName 返回按键的名称，可被 ParseKey 解析回原键码，同一键码有多个名称时取最短的
+func KeyName(code byte, isMouse bool) string {
+	pick := func(names map[string]byte) string {
+		best := ""
+		for name, c := range names {
+			if c == code && (best == "" || len(name) < len(best) || (len(name) == len(best) && name < best)) {
+				best = name
+			}
+		}
+		return best
+	}
+	if isMouse {
+		return pick(MouseBtnNames) // 只有标准鼠标按键有名称
+	}
+	name := pick(KeyNames)
+	if name == "" {
+		return strconv.Itoa(int(code))
+	}
+	if _, ok := MouseBtnNames[name]; ok {
+		return "key:" + name // left/right 等与鼠标按键重名
+	}
+	return name
+}
@@ -0,0 +1,114 @@
+package latency
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const windowSize = 1024 // 每个直方图保留最近的样本数
+
+// 样本分类
+const (
+	KindDevice  = "device"  // 内核时间戳 -> 管道处理完成(含后端写入)
+	KindStage   = "stage"   // 单个管道阶段耗时
+	KindBackend = "backend" // 后端写入耗时
+)
+
+// Histogram 滚动窗口内的延迟样本
+type Histogram struct {
+	samples []time.Duration
+	next    int
+	total   uint64
+	mutex   sync.Mutex
+}
+
+// Summary 延迟统计，单位毫秒
+type Summary struct {
+	Count uint64  `json:"count"`
+	P50   float64 `json:"p50_ms"`
+	P95   float64 `json:"p95_ms"`
+	P99   float64 `json:"p99_ms"`
+	Max   float64 `json:"max_ms"`
+}
+
+func (h *Histogram) Observe(d time.Duration) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if len(h.samples) < windowSize {
+		h.samples = append(h.samples, d)
+	} else {
+		h.samples[h.next] = d
+		h.next = (h.next + 1) % windowSize
+	}
+	h.total++
+}
+
+func (h *Histogram) Summary() Summary {
+	h.mutex.Lock()
+	sorted := make([]time.Duration, len(h.samples))
+	copy(sorted, h.samples)
+	total := h.total
+	h.mutex.Unlock()
+	if len(sorted) == 0 {
+		return Summary{}
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	percentile := func(p float64) float64 {
+		return toMs(sorted[int(p*float64(len(sorted)-1))])
+	}
+	return Summary{
+		Count: total,
+		P50:   percentile(0.50),
+		P95:   percentile(0.95),
+		P99:   percentile(0.99),
+		Max:   toMs(sorted[len(sorted)-1]),
+	}
+}
+
+func toMs(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+var (
+	histograms = make(map[string]map[string]*Histogram)
+	mutex      sync.Mutex
+)
+
+// Observe 记录一个样本，kind 为分类，name 为设备名/阶段名/后端名
+func Observe(kind, name string, d time.Duration) {
+	mutex.Lock()
+	named, ok := histograms[kind]
+	if !ok {
+		named = make(map[string]*Histogram)
+		histograms[kind] = named
+	}
+	h, ok := named[name]
+	if !ok {
+		h = &Histogram{}
+		named[name] = h
+	}
+	mutex.Unlock()
+	h.Observe(d)
+}
+
+// Snapshot 返回所有直方图的统计
+func Snapshot() map[string]map[string]Summary {
+	mutex.Lock()
+	defer mutex.Unlock()
+	result := make(map[string]map[string]Summary, len(histograms))
+	for kind, named := range histograms {
+		result[kind] = make(map[string]Summary, len(named))
+		for name, h := range named {
+			result[kind][name] = h.Summary()
+		}
+	}
+	return result
+}
+
+// Reset 清空所有样本
+func Reset() {
+	mutex.Lock()
+	defer mutex.Unlock()
+	histograms = make(map[string]map[string]*Histogram)
+}
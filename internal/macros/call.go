@@ -0,0 +1,74 @@
+package macros
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+// 宏之间的调用: 声明式宏的 call 步骤、脚本中的 call() 以及插件等外部代码调用 CallMacro
+
+// maxCallDepth 嵌套调用的最大层数，宏互相调用时不会无限递归
+const maxCallDepth = 8
+
+type callDepthKey struct{}
+
+// CallMacro 在 ctx 中执行另一个宏，返回时被调用的宏已经结束。binding 为宏名或带参数的调用，如 click_repeat(interval=30ms)。
+// ctx 结束(调用方的按键松开)时被调用的宏也收到松开信号，ctx 不会结束时(如在 on_release 中)立即收到松开信号。
+// 声明式宏执行完步骤后立即执行 on_release，不等待松开
+func (mk *MacroMouseKeyboard) CallMacro(ctx context.Context, binding string) error {
+	name, macro, args, err := resolveMacro(currentMacros(), binding)
+	if err != nil {
+		return err
+	}
+	return mk.callMacro(ctx, name, macro, args)
+}
+
+func (mk *MacroMouseKeyboard) callMacro(ctx context.Context, name string, macro Macro, args Args) error {
+	depth, _ := ctx.Value(callDepthKey{}).(int)
+	if depth >= maxCallDepth {
+		return fmt.Errorf("%s: macro calls nested deeper than %d", name, maxCallDepth)
+	}
+	ctx = context.WithValue(ctx, callDepthKey{}, depth+1)
+	if macro.def != nil {
+		// 声明式宏直接执行步骤和 on_release，在 on_release 中调用时步骤不会被打断
+		macro.def.runNested(context.WithValue(ctx, argsKey{}, args), mk)
+		return nil
+	}
+	var key bindingKey
+	if parent, ok := InvocationFromContext(ctx); ok {
+		key = parent.key
+	}
+	inv, sub := mk.newInvocation(ctx, key, name, args) // sub 随 ctx 一起结束
+	if ctx.Done() == nil {
+		inv.stop()
+	}
+	mk.runInvocation(sub, inv, macro)
+	return nil
+}
+
+// runFn 以 ctx 执行旧式宏函数，ctx 结束时发送停止信号
+func runFn(ctx context.Context, mk *MacroMouseKeyboard, fn func(*MacroMouseKeyboard, chan bool)) {
+	ch := make(chan bool, 1)
+	stop := context.AfterFunc(ctx, func() { ch <- true })
+	defer stop()
+	fn(mk, ch)
+}
+
+var refRe = regexp.MustCompile(`\$([A-Za-z_]\w*)`)
+
+// expandRefs 将调用中的 $name 替换为参数值，字符串参数加上引号
+func expandRefs(binding string, args Args) string {
+	return refRe.ReplaceAllStringFunc(binding, func(ref string) string {
+		name := ref[1:]
+		switch v := args[name].(type) {
+		case nil:
+			return ref
+		case string:
+			return strconv.Quote(v)
+		default:
+			return args.Text(name)
+		}
+	})
+}
@@ -0,0 +1,97 @@
+package macros
+
+import (
+	"context"
+	"fmt"
+	"input2com/internal/clock"
+	"input2com/internal/input"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeCtrl 记录收到的调用，设置了 clock 时在前面加上从 start 开始的毫秒数
+type fakeCtrl struct {
+	mutex sync.Mutex
+	calls []string
+	clock clock.Clock
+	start time.Time
+}
+
+func (f *fakeCtrl) record(format string, args ...any) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	call := fmt.Sprintf(format, args...)
+	if f.clock != nil {
+		call = fmt.Sprintf("%dms %s", f.clock.Now().Sub(f.start).Milliseconds(), call)
+	}
+	f.calls = append(f.calls, call)
+	return nil
+}
+
+// Calls 返回并清空已记录的调用
+func (f *fakeCtrl) Calls() []string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	calls := f.calls
+	f.calls = nil
+	return calls
+}
+
+func (f *fakeCtrl) MouseBtnDown(keyCode byte) error { return f.record("mdown %#x", keyCode) }
+func (f *fakeCtrl) MouseBtnUp(keyCode byte) error   { return f.record("mup %#x", keyCode) }
+func (f *fakeCtrl) MouseMove(dx, dy, wheel int32) error {
+	return f.record("move %d %d %d", dx, dy, wheel)
+}
+func (f *fakeCtrl) IsMouseBtnPressed(keyCode byte) bool { return false }
+func (f *fakeCtrl) KeyDown(keyCode byte) error          { return f.record("kdown %#x", keyCode) }
+func (f *fakeCtrl) KeyUp(keyCode byte) error            { return f.record("kup %#x", keyCode) }
+func (f *fakeCtrl) LockMouse(Button int, lock int) error {
+	return nil
+}
+func (f *fakeCtrl) Click(i int) error  { return nil }
+func (f *fakeCtrl) MouseBtnMask() byte { return input.HIDMouseBtns }
+
+// registerTestMacros 注册宏文件中的宏，测试结束后移除
+func registerTestMacros(t *testing.T, data string) {
+	defs, err := ParseMacroFile("test.yaml", []byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := make(map[string]Macro, len(defs))
+	for _, def := range defs {
+		m[def.Name] = def.Macro()
+	}
+	src := macroSource{kindFile, "test.yaml"}
+	setSource(src, m)
+	t.Cleanup(func() { setSource(src, nil) })
+}
+
+func TestCallDeclarativeContinues(t *testing.T) {
+	registerTestMacros(t, `
+- name: test_call_inner
+  steps: [{tap: {key: a, hold: 1ms}}]
+  on_release: [{tap: {key: c, hold: 1ms}}]
+- name: test_call_outer
+  steps: [{call: test_call_inner}, {tap: {key: b, hold: 1ms}}]
+`)
+	ctrl := &fakeCtrl{}
+	mk := &MacroMouseKeyboard{Ctrl: ctrl, Clock: clock.Real}
+	ctx, cancel := context.WithCancel(context.Background()) // 调用方一直按着
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- mk.CallMacro(ctx, "test_call_outer") }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("call blocked until release")
+	}
+	want := []string{"kdown 0x4", "kup 0x4", "kdown 0x6", "kup 0x6", "kdown 0x5", "kup 0x5"}
+	if got := ctrl.Calls(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
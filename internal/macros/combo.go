@@ -0,0 +1,182 @@
+package macros
+
+import (
+	"fmt"
+	"input2com/internal/clock"
+	"input2com/internal/config"
+	"input2com/internal/input"
+	"input2com/internal/logger"
+	"strings"
+	"sync"
+)
+
+// comboKey 组合键中的一个按键，不区分设备
+type comboKey struct {
+	isMouse bool
+	code    byte
+}
+
+func (e keyEvent) comboKey() comboKey {
+	return comboKey{e.isMouse, e.code}
+}
+
+// ComboBinding 解析后的组合键
+type ComboBinding struct {
+	Keys   []string `json:"keys"`
+	Action string   `json:"action"`
+	keys   map[comboKey]bool
+}
+
+var (
+	Combos     []*ComboBinding
+	ComboMutex sync.RWMutex
+)
+
+// LoadCombos 从配置加载组合键，需在宏和层加载之后调用
+func LoadCombos(combos []config.Combo) error {
+	macros := currentMacros()
+	result := make([]*ComboBinding, 0, len(combos))
+	for _, c := range combos {
+		if len(c.Keys) < 2 {
+			return fmt.Errorf("combo %v needs at least two keys", c.Keys)
+		}
+		b := &ComboBinding{Keys: c.Keys, Action: normalizeBinding(macros, c.Action), keys: make(map[comboKey]bool)}
+		for _, name := range c.Keys {
+			code, isMouse, ok := input.ParseKey(name)
+			if !ok {
+				return fmt.Errorf("combo %v: unknown key %q", c.Keys, name)
+			}
+			b.keys[comboKey{isMouse, code}] = true
+		}
+		if !isValidBinding(macros, b.Action) {
+			return fmt.Errorf("combo %v: invalid action %q", c.Keys, c.Action)
+		}
+		result = append(result, b)
+	}
+	ComboMutex.Lock()
+	Combos = result
+	ComboMutex.Unlock()
+	return nil
+}
+
+// comboPending 正在等待的组合键，期间的按键事件缓存起来，组合键没有触发时按原顺序重放
+type comboPending struct {
+	events []keyEvent
+	down   map[comboKey]bool
+	timer  clock.Timer
+}
+
+// activeCombo 已触发的组合键，组合中的按键松开时不转发，第一个按键松开时结束动作
+type activeCombo struct {
+	ev     keyEvent // 第一个按键，宏使用它的信号管道
+	action string
+	held   map[comboKey]bool
+	ended  bool
+}
+
+// matchCombo 返回包含全部 down 按键的组合键，exact 为按键完全相同的组合
+func matchCombo(down map[comboKey]bool) (partial bool, exact *ComboBinding) {
+	ComboMutex.RLock()
+	defer ComboMutex.RUnlock()
+	for _, c := range Combos {
+		contains := true
+		for k := range down {
+			contains = contains && c.keys[k]
+		}
+		if !contains {
+			continue
+		}
+		partial = true
+		if len(c.keys) == len(down) && exact == nil {
+			exact = c
+		}
+	}
+	return partial, exact
+}
+
+// processCombo 组合键处理，调用时需持有 inputMutex
+func (mk *MacroMouseKeyboard) processCombo(ev keyEvent) error {
+	if mk.consumeComboRelease(ev) {
+		return nil
+	}
+	p := mk.combo
+	if p == nil {
+		if !ev.down {
+			return mk.processTapHold(ev)
+		}
+		down := map[comboKey]bool{ev.comboKey(): true}
+		if partial, _ := matchCombo(down); !partial {
+			return mk.processTapHold(ev)
+		}
+		p = &comboPending{events: []keyEvent{ev}, down: down}
+		mk.combo = p
+		p.timer = clock.AfterFunc(mk.Clock, config.GetComboTerm(), func() {
+			mk.inputMutex.Lock()
+			defer mk.inputMutex.Unlock()
+			if mk.combo == p {
+				mk.flushCombo() // 超时，组合键没有按全
+			}
+		})
+		return nil
+	}
+	if ev.down && !p.down[ev.comboKey()] {
+		p.down[ev.comboKey()] = true
+		partial, exact := matchCombo(p.down)
+		if exact != nil {
+			mk.fireCombo(exact)
+			return nil
+		}
+		if partial {
+			p.events = append(p.events, ev)
+			return nil
+		}
+		delete(p.down, ev.comboKey())
+	}
+	// 其他按键或组合中的按键提前松开，组合键失败
+	mk.flushCombo()
+	return mk.processCombo(ev)
+}
+
+// flushCombo 组合键没有触发，按顺序重放缓存的事件
+func (mk *MacroMouseKeyboard) flushCombo() {
+	p := mk.combo
+	mk.combo = nil
+	p.timer.Stop()
+	for _, ev := range p.events {
+		mk.processTapHold(ev)
+	}
+}
+
+func (mk *MacroMouseKeyboard) fireCombo(c *ComboBinding) {
+	p := mk.combo
+	mk.combo = nil
+	p.timer.Stop()
+	logger.Logger.Debugf("触发组合键 %s -> %s", strings.Join(c.Keys, "+"), c.Action)
+	active := &activeCombo{ev: p.events[0], action: c.Action, held: p.down}
+	mk.combosActive = append(mk.combosActive, active)
+	mk.runBinding(active.ev, active.action)
+}
+
+// consumeComboRelease 吞掉已触发组合键中按键的松开事件，第一个松开时结束组合键的动作
+func (mk *MacroMouseKeyboard) consumeComboRelease(ev keyEvent) bool {
+	if ev.down {
+		return false
+	}
+	for i, c := range mk.combosActive {
+		if !c.held[ev.comboKey()] {
+			continue
+		}
+		delete(c.held, ev.comboKey())
+		if !c.ended {
+			c.ended = true
+			up := c.ev
+			up.down = false
+			mk.runBinding(up, c.action)
+		}
+		if len(c.held) == 0 {
+			mk.combosActive = append(mk.combosActive[:i], mk.combosActive[i+1:]...)
+		}
+		return true
+	}
+	return false
+}
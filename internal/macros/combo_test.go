@@ -0,0 +1,62 @@
+package macros
+
+import (
+	"input2com/internal/clock"
+	"input2com/internal/config"
+	"testing"
+	"time"
+)
+
+const (
+	evJ = 36 // evdev KEY_J, HID 0x0d
+	evK = 37 // evdev KEY_K, HID 0x0e
+	evB = 48 // evdev KEY_B, HID 0x05
+)
+
+func TestCombo(t *testing.T) {
+	if err := LoadCombos([]config.Combo{{Keys: []string{"j", "k"}, Action: "esc"}}); err != nil {
+		t.Fatal(err)
+	}
+	defer LoadCombos(nil)
+	tests := []struct {
+		name string
+		run  func(mk *MacroMouseKeyboard, fc *clock.Fake)
+		want []string
+	}{
+		{"all keys within term", func(mk *MacroMouseKeyboard, fc *clock.Fake) {
+			mk.KeyDown(evJ, "kbd")
+			fc.Advance(20 * time.Millisecond)
+			mk.KeyDown(evK, "kbd")
+			fc.Advance(100 * time.Millisecond)
+			mk.KeyUp(evK, "kbd") // 第一个松开的按键结束动作
+			mk.KeyUp(evJ, "kbd")
+		}, []string{"20ms kdown 0x29", "120ms kup 0x29"}},
+		{"timeout replays the first key", func(mk *MacroMouseKeyboard, fc *clock.Fake) {
+			mk.KeyDown(evJ, "kbd")
+			fc.Advance(60 * time.Millisecond)
+			mk.KeyUp(evJ, "kbd")
+		}, []string{"50ms kdown 0xd", "60ms kup 0xd"}},
+		{"partial release within term", func(mk *MacroMouseKeyboard, fc *clock.Fake) {
+			mk.KeyDown(evJ, "kbd")
+			fc.Advance(10 * time.Millisecond)
+			mk.KeyUp(evJ, "kbd")
+			fc.Advance(100 * time.Millisecond) // 已经重放，超时不再有作用
+		}, []string{"10ms kdown 0xd", "10ms kup 0xd"}},
+		{"other key breaks the combo", func(mk *MacroMouseKeyboard, fc *clock.Fake) {
+			mk.KeyDown(evJ, "kbd")
+			mk.KeyDown(evA, "kbd")
+			mk.KeyUp(evA, "kbd")
+			mk.KeyUp(evJ, "kbd")
+		}, []string{"0ms kdown 0xd", "0ms kdown 0x4", "0ms kup 0x4", "0ms kup 0xd"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mk, ctrl, fc := fakeKB()
+			tt.run(mk, fc)
+			expectCalls(t, ctrl, tt.want...)
+			if mk.combo != nil || len(mk.combosActive) != 0 {
+				t.Error("combo state left behind")
+			}
+		})
+	}
+}
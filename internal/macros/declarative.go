@@ -0,0 +1,617 @@
+package macros
+
+import (
+	"context"
+	"fmt"
+	"input2com/internal/clock"
+	"input2com/internal/config"
+	"input2com/internal/input"
+	"input2com/internal/logger"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// 声明式宏的步骤类型
+const (
+	StepPress   = "press"   // 按下按键 press: left / press: key:a
+	StepRelease = "release" // 松开按键
+	StepTap     = "tap"     // 按下后松开 tap: a 或 tap: {key: a, hold: 20ms}
+	StepMove    = "move"    // 相对移动 move: {x: 10, y: -5}
+	StepWheel   = "wheel"   // 滚轮 wheel: -1
+	StepWait    = "wait"    // 等待 wait: 50ms
+	StepRepeat  = "repeat"  // 重复 repeat: {count: 3, steps: [...]}
+	StepLoop    = "loop"    // 循环直到按键松开 loop: [...]
+	StepType    = "type"    // 输入文本 type: "hello" 或 type: {text: hello, layout: de, delay: 20ms}
+	StepCall    = "call"    // 调用其他宏 call: click_repeat(interval=$interval)
+)
+
+const defaultTapHold = 10 * time.Millisecond
+
+// minLoopPeriod loop 每一轮至少花费的时间，等待的时长由参数传入 0 时也不会空转
+const minLoopPeriod = time.Millisecond
+
+// Step 声明式宏的一个步骤
+type Step struct {
+	Kind     string            `json:"kind"`
+	Code     byte              `json:"code,omitempty"`
+	IsMouse  bool              `json:"is_mouse,omitempty"`
+	Duration time.Duration     `json:"duration,omitempty"`
+	X        int32             `json:"x,omitempty"`
+	Y        int32             `json:"y,omitempty"`
+	Wheel    int32             `json:"wheel,omitempty"`
+	Count    int               `json:"count,omitempty"`
+	Text     string            `json:"text,omitempty"`
+	Layout   string            `json:"layout,omitempty"`
+	Steps    []Step            `json:"steps,omitempty"`
+	Call     string            `json:"call,omitempty"`
+	Refs     map[string]string `json:"refs,omitempty"` // 字段 -> 引用的参数名，执行时替换为参数值
+	Line     int               `json:"line"`
+}
+
+// MacroDefinition 声明式宏定义，steps 执行完后等待按键松开，再执行 on_release。
+// 步骤中的值可以写成 $参数名，如 wait: $interval
+type MacroDefinition struct {
+	Name        string  `json:"name" yaml:"name"`
+	Description string  `json:"description" yaml:"description,omitempty"`
+	Params      []Param `json:"params,omitempty" yaml:"params,omitempty"`
+	Steps       []Step  `json:"steps" yaml:"steps"`
+	OnRelease   []Step  `json:"on_release" yaml:"on_release,omitempty"`
+	File        string  `json:"file" yaml:"-"`
+}
+
+// parseError 带文件名和行号的错误
+func parseError(file string, node *yaml.Node, format string, args ...any) error {
+	return fmt.Errorf("%s:%d: %s", file, node.Line, fmt.Sprintf(format, args...))
+}
+
+// ParseMacroFile 解析宏定义文件，文件可以是单个宏或宏列表，YAML 和 JSON 都可以
+func ParseMacroFile(file string, data []byte) ([]*MacroDefinition, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	if len(doc.Content) == 0 {
+		return nil, nil
+	}
+	root := doc.Content[0]
+	nodes := []*yaml.Node{root}
+	if root.Kind == yaml.SequenceNode {
+		nodes = root.Content
+	}
+	defs := make([]*MacroDefinition, 0, len(nodes))
+	for _, node := range nodes {
+		def, err := parseDefinition(file, node)
+		if err != nil {
+			return nil, err
+		}
+		defs = append(defs, def)
+	}
+	return defs, nil
+}
+
+func parseDefinition(file string, node *yaml.Node) (*MacroDefinition, error) {
+	if node.Kind != yaml.MappingNode {
+		return nil, parseError(file, node, "macro must be a mapping")
+	}
+	def := &MacroDefinition{File: file}
+	for i := 0; i < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		var err error
+		switch key.Value {
+		case "name":
+			def.Name = value.Value
+		case "description":
+			def.Description = value.Value
+		case "params":
+			if err = value.Decode(&def.Params); err == nil {
+				err = ValidateParams(def.Params)
+			}
+			if err != nil {
+				err = parseError(file, value, "invalid params: %v", err)
+			}
+		case "steps":
+			def.Steps, err = parseSteps(file, value)
+		case "on_release":
+			def.OnRelease, err = parseSteps(file, value)
+		default:
+			err = parseError(file, key, "unknown field %q", key.Value)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if def.Name == "" {
+		return nil, parseError(file, node, "macro name is required")
+	}
+	if err := checkRefs(file, def.Params, def.Steps); err != nil {
+		return nil, err
+	}
+	if err := checkRefs(file, def.Params, def.OnRelease); err != nil {
+		return nil, err
+	}
+	return def, nil
+}
+
+// refTypes 可以引用参数的字段和需要的参数类型，text 可以引用任意类型的参数
+var refTypes = map[string]string{
+	"key": ParamKey, "hold": ParamDuration, "wait": ParamDuration, "delay": ParamDuration,
+	"x": ParamInt, "y": ParamInt, "wheel": ParamInt, "count": ParamInt, "text": "",
+}
+
+// checkRefs 检查步骤引用的参数是否存在、类型是否匹配
+func checkRefs(file string, params []Param, steps []Step) error {
+	macro := Macro{Params: params}
+	for _, step := range steps {
+		for field, name := range step.Refs {
+			p, ok := macro.param(name)
+			if !ok {
+				return fmt.Errorf("%s:%d: unknown parameter $%s", file, step.Line, name)
+			}
+			if typ := refTypes[field]; typ != "" && p.Type != typ {
+				return fmt.Errorf("%s:%d: %s requires a %s parameter, $%s is %s", file, step.Line, field, typ, name, p.Type)
+			}
+		}
+		for _, m := range refRe.FindAllStringSubmatch(step.Call, -1) {
+			if _, ok := macro.param(m[1]); !ok {
+				return fmt.Errorf("%s:%d: unknown parameter $%s", file, step.Line, m[1])
+			}
+		}
+		if err := checkRefs(file, params, step.Steps); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ref 值为 $参数名 时记录引用，执行时再替换为参数值
+func (s *Step) ref(field string, node *yaml.Node) bool {
+	if node.Kind != yaml.ScalarNode || !strings.HasPrefix(node.Value, "$") || !isIdent(node.Value[1:]) {
+		return false
+	}
+	if s.Refs == nil {
+		s.Refs = make(map[string]string)
+	}
+	s.Refs[field] = node.Value[1:]
+	return true
+}
+
+// bind 返回用参数值替换引用后的步骤
+func (s Step) bind(args Args) Step {
+	for field, name := range s.Refs {
+		switch field {
+		case "key":
+			key := args.Key(name)
+			s.Code, s.IsMouse = key.Code, key.IsMouse
+		case "hold", "wait", "delay":
+			s.Duration = args.Duration(name)
+		case "x":
+			s.X = int32(args.Int(name))
+		case "y":
+			s.Y = int32(args.Int(name))
+		case "wheel":
+			s.Wheel = int32(args.Int(name))
+		case "count":
+			s.Count = args.Int(name)
+		case "text":
+			s.Text = args.Text(name)
+		}
+	}
+	if s.Call != "" {
+		s.Call = expandRefs(s.Call, args)
+	}
+	return s
+}
+
+func parseSteps(file string, node *yaml.Node) ([]Step, error) {
+	if node.Kind != yaml.SequenceNode {
+		return nil, parseError(file, node, "steps must be a list")
+	}
+	steps := make([]Step, 0, len(node.Content))
+	for _, item := range node.Content {
+		if item.Kind != yaml.MappingNode || len(item.Content) != 2 {
+			return nil, parseError(file, item, "each step must be a single-key mapping such as \"wait: 10ms\"")
+		}
+		step, err := parseStep(file, item.Content[0], item.Content[1])
+		if err != nil {
+			return nil, err
+		}
+		steps = append(steps, step)
+	}
+	return steps, nil
+}
+
+// fields 将映射节点转换为 字段名 -> 值节点
+func fields(file string, node *yaml.Node, allowed ...string) (map[string]*yaml.Node, error) {
+	if node.Kind != yaml.MappingNode {
+		return nil, parseError(file, node, "expected a mapping")
+	}
+	result := make(map[string]*yaml.Node)
+	for i := 0; i < len(node.Content); i += 2 {
+		key := node.Content[i]
+		valid := false
+		for _, a := range allowed {
+			valid = valid || a == key.Value
+		}
+		if !valid {
+			return nil, parseError(file, key, "unknown field %q", key.Value)
+		}
+		result[key.Value] = node.Content[i+1]
+	}
+	return result, nil
+}
+
+func parseInt(file string, node *yaml.Node) (int32, error) {
+	n, err := strconv.ParseInt(node.Value, 10, 32)
+	if node.Kind != yaml.ScalarNode || err != nil {
+		return 0, parseError(file, node, "expected an integer, got %q", node.Value)
+	}
+	return int32(n), nil
+}
+
+func parseDuration(file string, node *yaml.Node) (time.Duration, error) {
+	if node.Kind == yaml.ScalarNode {
+		if d, err := time.ParseDuration(node.Value); err == nil && d >= 0 {
+			return d, nil
+		}
+		if ms, err := strconv.ParseUint(node.Value, 10, 32); err == nil {
+			return time.Duration(ms) * time.Millisecond, nil // 纯数字按毫秒
+		}
+	}
+	return 0, parseError(file, node, "invalid duration %q", node.Value)
+}
+
+func parseTarget(file string, node *yaml.Node) (byte, bool, error) {
+	code, isMouse, ok := input.ParseKey(node.Value)
+	if node.Kind != yaml.ScalarNode || !ok {
+		return 0, false, parseError(file, node, "unknown key or button %q", node.Value)
+	}
+	return code, isMouse, nil
+}
+
+func parseStep(file string, key, value *yaml.Node) (Step, error) {
+	step := Step{Kind: key.Value, Line: key.Line}
+	var err error
+	switch key.Value {
+	case StepPress, StepRelease:
+		if !step.ref("key", value) {
+			step.Code, step.IsMouse, err = parseTarget(file, value)
+		}
+	case StepTap:
+		step.Duration = defaultTapHold
+		if value.Kind == yaml.ScalarNode {
+			if !step.ref("key", value) {
+				step.Code, step.IsMouse, err = parseTarget(file, value)
+			}
+			break
+		}
+		f, ferr := fields(file, value, "key", "hold")
+		if ferr != nil {
+			return step, ferr
+		}
+		if f["key"] == nil {
+			return step, parseError(file, value, "tap requires key")
+		}
+		if !step.ref("key", f["key"]) {
+			step.Code, step.IsMouse, err = parseTarget(file, f["key"])
+		}
+		if err == nil && f["hold"] != nil && !step.ref("hold", f["hold"]) {
+			step.Duration, err = parseDuration(file, f["hold"])
+		}
+	case StepMove:
+		f, ferr := fields(file, value, "x", "y")
+		if ferr != nil {
+			return step, ferr
+		}
+		if f["x"] != nil && !step.ref("x", f["x"]) {
+			step.X, err = parseInt(file, f["x"])
+		}
+		if err == nil && f["y"] != nil && !step.ref("y", f["y"]) {
+			step.Y, err = parseInt(file, f["y"])
+		}
+	case StepWheel:
+		if !step.ref("wheel", value) {
+			step.Wheel, err = parseInt(file, value)
+		}
+	case StepWait:
+		if !step.ref("wait", value) {
+			step.Duration, err = parseDuration(file, value)
+		}
+	case StepRepeat:
+		f, ferr := fields(file, value, "count", "steps")
+		if ferr != nil {
+			return step, ferr
+		}
+		if f["count"] == nil || f["steps"] == nil {
+			return step, parseError(file, value, "repeat requires count and steps")
+		}
+		if !step.ref("count", f["count"]) {
+			var count int32
+			if count, err = parseInt(file, f["count"]); err != nil {
+				return step, err
+			}
+			if count <= 0 {
+				return step, parseError(file, f["count"], "repeat count must be positive")
+			}
+			step.Count = int(count)
+		}
+		step.Steps, err = parseSteps(file, f["steps"])
+	case StepLoop:
+		step.Steps, err = parseSteps(file, value)
+		if err == nil && !hasWait(step.Steps) {
+			err = parseError(file, value, "loop must contain a wait, tap, type or call step, otherwise it spins")
+		}
+	case StepType:
+		if value.Kind == yaml.ScalarNode {
+			if !step.ref("text", value) {
+				step.Text = value.Value
+			}
+		} else {
+			f, ferr := fields(file, value, "text", "layout", "delay")
+			if ferr != nil {
+				return step, ferr
+			}
+			if f["text"] == nil {
+				return step, parseError(file, value, "type requires text")
+			}
+			if !step.ref("text", f["text"]) {
+				step.Text = f["text"].Value
+			}
+			if f["layout"] != nil {
+				step.Layout = f["layout"].Value
+			}
+			if f["delay"] != nil && !step.ref("delay", f["delay"]) {
+				step.Duration, err = parseDuration(file, f["delay"])
+			}
+		}
+		if _, terr := ParseText(step.Text, step.Layout); err == nil && terr != nil {
+			err = parseError(file, value, "%v", terr)
+		}
+	case StepCall:
+		step.Call = value.Value
+		valid := value.Kind == yaml.ScalarNode && isIdent(step.Call)
+		if _, _, ok := ParseMacroCall(step.Call); ok {
+			valid = true
+		}
+		if !valid {
+			err = parseError(file, value, "call expects a macro name or a call such as name(arg=1), got %q", value.Value)
+		}
+	default:
+		err = parseError(file, key, "unknown step %q", key.Value)
+	}
+	return step, err
+}
+
+// hasWait 步骤中是否有会等待的步骤
+func hasWait(steps []Step) bool {
+	for _, s := range steps {
+		switch s.Kind {
+		case StepWait, StepTap:
+			if s.Duration > 0 || s.Refs["wait"] != "" || s.Refs["hold"] != "" {
+				return true
+			}
+		case StepType, StepCall:
+			return true
+		case StepRepeat:
+			if hasWait(s.Steps) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// MarshalYAML 以宏文件中的写法输出步骤，保存的文件可以再被 ParseMacroFile 加载
+func (s Step) MarshalYAML() (any, error) {
+	// or 引用参数的字段输出 $参数名
+	or := func(field string, v any) any {
+		if name, ok := s.Refs[field]; ok {
+			return "$" + name
+		}
+		return v
+	}
+	key := or("key", input.KeyName(s.Code, s.IsMouse))
+	var value any
+	switch s.Kind {
+	case StepPress, StepRelease:
+		value = key
+	case StepTap:
+		value = map[string]any{"key": key, "hold": or("hold", s.Duration.String())}
+	case StepMove:
+		value = map[string]any{"x": or("x", s.X), "y": or("y", s.Y)}
+	case StepWheel:
+		value = or("wheel", s.Wheel)
+	case StepWait:
+		value = or("wait", s.Duration.String())
+	case StepRepeat:
+		value = map[string]any{"count": or("count", s.Count), "steps": s.Steps}
+	case StepLoop:
+		value = s.Steps
+	case StepType:
+		value = or("text", s.Text)
+		if s.Layout != "" || s.Duration > 0 || s.Refs["delay"] != "" {
+			value = map[string]any{"text": or("text", s.Text), "layout": s.Layout, "delay": or("delay", s.Duration.String())}
+		}
+	case StepCall:
+		value = s.Call
+	default:
+		return nil, fmt.Errorf("unknown step %q", s.Kind)
+	}
+	return map[string]any{s.Kind: value}, nil
+}
+
+// SaveMacroFile 将宏定义保存到宏目录下的 <name>.yaml
+func SaveMacroFile(def *MacroDefinition) error {
+	data, err := yaml.Marshal([]*MacroDefinition{def})
+	if err != nil {
+		return err
+	}
+	dir := config.GetMacrosDir()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	def.File = filepath.Join(dir, def.Name+".yaml")
+	return os.WriteFile(def.File, data, 0644)
+}
+
+// RegisterMacro 注册宏，同名宏会被替换。def.File 为空时作为单独的来源
+func RegisterMacro(def *MacroDefinition) {
+	file := def.File
+	if file == "" {
+		file = "(" + def.Name + ")"
+	}
+	setSource(macroSource{kindFile, file}, map[string]Macro{def.Name: def.Macro()})
+}
+
+// runSteps 执行步骤，ctx 结束(按键松开)后立即返回 false，引用的参数从 ctx 中读取。
+// 等待的时长按 sched 的截止时间计算，执行步骤花费的时间不会累积
+func runSteps(ctx context.Context, mk *MacroMouseKeyboard, sched *clock.Scheduler, steps []Step) bool {
+	released := ctx.Done() // on_release 中为 nil
+	args := ArgsFromContext(ctx)
+	wait := func(d time.Duration) bool {
+		return sched.Wait(d, released)
+	}
+	isReleased := func() bool {
+		select {
+		case <-released:
+			return true
+		default:
+			return false // released 为 nil 时永远不会松开
+		}
+	}
+	for _, step := range steps {
+		if isReleased() {
+			return false
+		}
+		if step.Refs != nil || step.Call != "" {
+			step = step.bind(args)
+		}
+		switch step.Kind {
+		case StepPress:
+			stepPress(mk, step, true)
+		case StepRelease:
+			stepPress(mk, step, false)
+		case StepTap:
+			stepPress(mk, step, true)
+			ok := wait(step.Duration)
+			stepPress(mk, step, false)
+			if !ok {
+				return false
+			}
+		case StepMove:
+			mk.MouseMove(step.X, step.Y, 0)
+		case StepWheel:
+			mk.MouseMove(0, 0, step.Wheel)
+		case StepWait:
+			if !wait(step.Duration) {
+				return false
+			}
+		case StepRepeat:
+			for i := 0; i < step.Count; i++ {
+				if !runSteps(ctx, mk, sched, step.Steps) {
+					return false
+				}
+			}
+		case StepType:
+			if err := mk.TypeText(step.Text, step.Layout, step.Duration, released); err != nil {
+				logger.Logger.Errorf("Type text failed: %v", err)
+			}
+			sched.Reset()
+			if isReleased() {
+				return false
+			}
+		case StepLoop:
+			if released == nil {
+				continue // on_release 中没有松开信号，loop 不执行
+			}
+			for {
+				start := sched.Deadline()
+				if !runSteps(ctx, mk, sched, step.Steps) {
+					return false
+				}
+				if spent := sched.Deadline().Sub(start); spent < minLoopPeriod && !wait(minLoopPeriod-spent) {
+					return false
+				}
+			}
+		case StepCall:
+			if err := mk.CallMacro(ctx, step.Call); err != nil {
+				logger.Logger.Errorf("Call macro failed at line %d: %v", step.Line, err)
+			}
+			sched.Reset()
+			if isReleased() {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func stepPress(mk *MacroMouseKeyboard, step Step, down bool) {
+	mk.sendKey(step.Code, step.IsMouse, down)
+}
+
+// Macro 将定义转换为宏，steps 在按键松开时中止
+func (def *MacroDefinition) Macro() Macro {
+	return Macro{
+		Name:        def.Name,
+		Description: def.Description,
+		Params:      def.Params,
+		Run:         def.run,
+		def:         def,
+	}
+}
+
+// run 执行 steps，等待松开后执行 on_release
+func (def *MacroDefinition) run(ctx context.Context, mk *MacroMouseKeyboard) {
+	runSteps(ctx, mk, mk.scheduler(), def.Steps)
+	mk.waitRelease(ctx)
+	runSteps(context.WithoutCancel(ctx), mk, mk.scheduler(), def.OnRelease)
+}
+
+// runNested 被其他宏调用时执行: steps 执行完(或调用方松开)后立即执行 on_release，不等待松开，
+// 调用方可以继续执行后面的步骤
+func (def *MacroDefinition) runNested(ctx context.Context, mk *MacroMouseKeyboard) {
+	runSteps(ctx, mk, mk.scheduler(), def.Steps)
+	runSteps(context.WithoutCancel(ctx), mk, mk.scheduler(), def.OnRelease)
+}
+
+// LoadMacroDir 加载目录下的声明式宏，每个文件是一个来源。出错的文件记录错误并保留上一次加载的宏，
+// 已删除的文件对应的宏会被移除
+func LoadMacroDir(dir string) {
+	found := make(map[string]bool)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		logger.Logger.Warnf("Failed to read macros directory: %v", err)
+	}
+	for _, entry := range entries {
+		ext := filepath.Ext(entry.Name())
+		if entry.IsDir() || (ext != ".yaml" && ext != ".yml" && ext != ".json") {
+			continue
+		}
+		file := filepath.Join(dir, entry.Name())
+		src := macroSource{kindFile, file}
+		found[file] = true
+		data, err := os.ReadFile(file)
+		if err != nil {
+			logger.Logger.Errorf("Failed to read macro file: %v", setLoadError(src, err))
+			continue
+		}
+		defs, err := ParseMacroFile(file, data)
+		if err != nil {
+			logger.Logger.Errorf("Invalid macro file: %v", setLoadError(src, err))
+			continue
+		}
+		m := make(map[string]Macro, len(defs))
+		for _, def := range defs {
+			m[def.Name] = def.Macro()
+			logger.Logger.Infof("Loaded macro %s from %s", def.Name, file)
+		}
+		setLoadError(src, nil)
+		setSource(src, m)
+	}
+	removeMissing(kindFile, found)
+}
@@ -0,0 +1,35 @@
+package macros
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseLoop(t *testing.T) {
+	tests := []struct {
+		name  string
+		steps string
+		err   string
+	}{
+		{"wait", "[{loop: [{tap: left}, {wait: 35ms}]}]", ""},
+		{"tap hold", "[{loop: [{tap: left}]}]", ""},
+		{"wait from param", "[{loop: [{move: {x: 1}}, {wait: $interval}]}]", ""},
+		{"wait inside repeat", "[{loop: [{repeat: {count: 2, steps: [{wait: 5ms}]}}]}]", ""},
+		{"call", "[{loop: [{call: other}]}]", ""},
+		{"empty", "[{loop: []}]", "test.yaml:3: loop must contain"},
+		{"no wait", "[{loop: [{move: {x: 1}}, {wheel: 1}]}]", "test.yaml:3: loop must contain"},
+		{"zero wait", "[{loop: [{press: left}, {wait: 0ms}, {release: left}]}]", "test.yaml:3: loop must contain"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := "- name: m\n  params: [{name: interval, type: duration, default: 10ms}]\n  steps: " + tt.steps + "\n"
+			_, err := ParseMacroFile("test.yaml", []byte(data))
+			if tt.err == "" && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+				t.Fatalf("error = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package macros
+
+import "strings"
+
+// keyEvent 一次鼠标按键或键盘按键的按下/松开，code 为鼠标按键位或HID键码
+type keyEvent struct {
+	devName string
+	isMouse bool
+	code    byte
+	evCode  uint16 // 键盘按键的 evdev 键码
+	down    bool
+}
+
+func (e keyEvent) key() bindingKey {
+	return bindingKey{strings.ToLower(e.devName), e.isMouse, e.code}
+}
+
+// feed 按键事件的入口，事件按顺序经过 leader -> 组合键 -> tap-hold 处理后分发
+func (mk *MacroMouseKeyboard) feed(ev keyEvent) error {
+	mk.inputMutex.Lock()
+	defer mk.inputMutex.Unlock()
+	return mk.processLeader(ev)
+}
+
+// dispatch 按绑定执行宏或层切换，没有绑定的按键直接转发给控制器
+func (mk *MacroMouseKeyboard) dispatch(ev keyEvent) error {
+	if ev.down {
+		if mk.pressBinding(ev.devName, ev.isMouse, ev.code) {
+			return nil
+		}
+		if ev.isMouse {
+			return mk.forwardBtnDown(ev.code)
+		}
+		if err := mk.Ctrl.KeyDown(ev.code); err != nil {
+			return err
+		}
+		mk.forwarded[ev.code] = true
+		mk.startKeyRepeat(ev.code)
+		return nil
+	}
+	if mk.releaseBinding(ev.devName, ev.isMouse, ev.code) {
+		return nil
+	}
+	if ev.isMouse {
+		return mk.forwardBtnUp(ev.code)
+	}
+	mk.stopKeyRepeat(ev.code)
+	delete(mk.forwarded, ev.code)
+	return mk.Ctrl.KeyUp(ev.code)
+}
+
+// runBinding 以 ev 的按下/松开执行一个绑定，宏的调用与 ev 所在按键关联
+func (mk *MacroMouseKeyboard) runBinding(ev keyEvent, binding string) {
+	mk.applyBinding(ev.key(), binding, ev.down)
+}
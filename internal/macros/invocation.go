@@ -0,0 +1,150 @@
+package macros
+
+import (
+	"context"
+	"fmt"
+	"input2com/internal/clock"
+	"sort"
+	"time"
+)
+
+// Invocation 一次宏调用，按下时创建，松开或取消时结束
+type Invocation struct {
+	ID      uint64    `json:"id"`
+	Macro   string    `json:"macro"`
+	Source  string    `json:"source"`  // 输入源(设备名)
+	Trigger string    `json:"trigger"` // 触发的按键，如 mouse:0x01、key:0x04
+	Args    Args      `json:"args,omitempty"`
+	Parent  uint64    `json:"parent,omitempty"` // 由其他宏调用时为调用方的 ID
+	Started time.Time `json:"started"`
+
+	key    bindingKey
+	cancel context.CancelFunc
+}
+
+type invocationKey struct{}
+
+// InvocationFromContext 返回 Run 的 ctx 对应的宏调用
+func InvocationFromContext(ctx context.Context) (*Invocation, bool) {
+	inv, ok := ctx.Value(invocationKey{}).(*Invocation)
+	return inv, ok
+}
+
+// stop 通知宏停止，可以重复调用
+func (inv *Invocation) stop() {
+	inv.cancel()
+}
+
+// startMacro 为按下的按键启动一次宏调用，同一按键上一次调用没有收到松开时先停止它
+func (mk *MacroMouseKeyboard) startMacro(key bindingKey, name string, macro Macro, args Args) *Invocation {
+	inv, ctx := mk.newInvocation(context.Background(), key, name, args)
+	mk.invocationMutex.Lock()
+	if old, ok := mk.held[key]; ok {
+		old.stop()
+	}
+	mk.held[key] = inv
+	mk.invocationMutex.Unlock()
+	go mk.runInvocation(ctx, inv, macro)
+	return inv
+}
+
+// newInvocation 登记一次调用，返回的 ctx 在调用停止或 parent 结束时结束
+func (mk *MacroMouseKeyboard) newInvocation(parent context.Context, key bindingKey, name string, args Args) (*Invocation, context.Context) {
+	ctx, cancel := context.WithCancel(parent)
+	kind := "key"
+	if key.isMouse {
+		kind = "mouse"
+	}
+	inv := &Invocation{
+		Macro:   name,
+		Source:  key.source,
+		Trigger: fmt.Sprintf("%s:0x%02X", kind, key.code),
+		Args:    args,
+		Started: mk.Clock.Now(),
+		key:     key,
+		cancel:  cancel,
+	}
+	if p, ok := InvocationFromContext(parent); ok {
+		inv.Parent = p.ID
+	}
+	mk.invocationMutex.Lock()
+	mk.lastInvocationID++
+	inv.ID = mk.lastInvocationID
+	mk.invocations[inv.ID] = inv
+	mk.invocationMutex.Unlock()
+	ctx = context.WithValue(ctx, invocationKey{}, inv)
+	ctx = context.WithValue(ctx, argsKey{}, args)
+	return inv, ctx
+}
+
+// runInvocation 执行宏函数直到返回，旧式宏(Fn)经 runFn 在 ctx 结束时收到停止信号
+func (mk *MacroMouseKeyboard) runInvocation(ctx context.Context, inv *Invocation, macro Macro) {
+	defer mk.finishMacro(inv)
+	if macro.Run != nil {
+		macro.Run(ctx, mk)
+	} else {
+		runFn(ctx, mk, macro.Fn)
+	}
+}
+
+// finishMacro 宏函数返回后从登记表中移除。按键仍按着时保留在 held 中，
+// 松开时由 releaseMacro 移除，这样宏被提前结束或移除后松开事件也不会被转发
+func (mk *MacroMouseKeyboard) finishMacro(inv *Invocation) {
+	inv.cancel()
+	clock.Idle(mk.Clock)
+	mk.invocationMutex.Lock()
+	defer mk.invocationMutex.Unlock()
+	delete(mk.invocations, inv.ID)
+}
+
+// releaseMacro 按键松开，停止该按键按下时启动的那次调用，返回该按键是否启动过宏
+func (mk *MacroMouseKeyboard) releaseMacro(key bindingKey) bool {
+	mk.invocationMutex.Lock()
+	inv, ok := mk.held[key]
+	delete(mk.held, key)
+	mk.invocationMutex.Unlock()
+	if ok {
+		inv.stop()
+	}
+	return ok
+}
+
+// Running 返回正在运行的宏调用，按启动顺序排列
+func (mk *MacroMouseKeyboard) Running() []*Invocation {
+	mk.invocationMutex.Lock()
+	defer mk.invocationMutex.Unlock()
+	result := make([]*Invocation, 0, len(mk.invocations))
+	for _, inv := range mk.invocations {
+		result = append(result, inv)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].ID < result[j].ID })
+	return result
+}
+
+// CancelMacro 停止某次宏调用，与松开按键效果相同
+func (mk *MacroMouseKeyboard) CancelMacro(id uint64) error {
+	mk.invocationMutex.Lock()
+	inv, ok := mk.invocations[id]
+	mk.invocationMutex.Unlock()
+	if !ok {
+		return fmt.Errorf("macro invocation %d is not running", id)
+	}
+	inv.stop()
+	return nil
+}
+
+// CancelAll 停止所有正在运行的宏
+func (mk *MacroMouseKeyboard) CancelAll() {
+	for _, inv := range mk.Running() {
+		inv.stop()
+	}
+}
+
+// CancelMacroNamed 停止某个宏的所有调用，用于宏被移除时
+func (mk *MacroMouseKeyboard) CancelMacroNamed(name string) {
+	for _, inv := range mk.Running() {
+		if inv.Macro == name {
+			inv.stop()
+		}
+	}
+}
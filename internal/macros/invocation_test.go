@@ -0,0 +1,66 @@
+package macros
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+// runningMacros 返回正在运行的宏名，等待 finishMacro 移除已结束的调用
+func runningMacros(t *testing.T, mk *MacroMouseKeyboard, want ...string) {
+	t.Helper()
+	var names []string
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		names = names[:0]
+		for _, inv := range mk.Running() {
+			names = append(names, inv.Macro)
+		}
+		if slices.Equal(names, want) {
+			return
+		}
+	}
+	t.Fatalf("running %q, want %q", names, want)
+}
+
+func TestCancelMacroNamed(t *testing.T) {
+	registerTestMacros(t, `
+- name: test_cancel_other
+  steps:
+    - tap: {key: b, hold: 20ms}
+`)
+	mk, ctrl, fc := fakeKB()
+	start := func(key bindingKey, binding string) *Invocation {
+		name, macro, args, err := resolveMacro(currentMacros(), binding)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return mk.startMacro(key, name, macro, args)
+	}
+	clickKey := bindingKey{source: "mouse0", isMouse: true, code: 0x04}
+	start(clickKey, "click_repeat(interval=50ms)")
+	fc.BlockUntil(1)
+	other := start(bindingKey{source: "kbd", code: 0x04}, "test_cancel_other")
+	fc.BlockUntil(2)
+	expectCalls(t, ctrl, "0ms mdown 0x1", "0ms kdown 0x5")
+	fc.Advance(50 * time.Millisecond)
+	expectCalls(t, ctrl, "10ms mup 0x1", "20ms kup 0x5", "50ms mdown 0x1")
+	runningMacros(t, mk, "click_repeat", "test_cancel_other")
+
+	mk.CancelMacroNamed("click_repeat") // 按下的这次单击仍会完整松开
+	fc.Advance(100 * time.Millisecond)
+	expectCalls(t, ctrl, "60ms mup 0x1")
+	runningMacros(t, mk, "test_cancel_other")
+	if !mk.releaseMacro(clickKey) {
+		t.Error("release after cancel was not consumed")
+	}
+
+	if err := mk.CancelMacro(other.ID); err != nil {
+		t.Fatal(err)
+	}
+	runningMacros(t, mk)
+	if err := mk.CancelMacro(other.ID); err == nil {
+		t.Error("cancelling a finished invocation succeeded")
+	}
+	mk.releaseMacro(other.key)
+	expectCalls(t, ctrl)
+}
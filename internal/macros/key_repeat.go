@@ -0,0 +1,85 @@
+package macros
+
+import (
+	"input2com/internal/config"
+	"input2com/internal/input"
+	"time"
+)
+
+// KeyRepeat 处理内核产生的按键重复事件(evdev value==2)。只重复按下时转发给了目标端的按键，
+// 绑定了宏、被 leader 吞掉或切换方案时已经释放的按键不会再次按下
+func (mk *MacroMouseKeyboard) KeyRepeat(keyCode uint16) error {
+	hid := input.Linux2hid[keyCode]
+	mk.inputMutex.Lock()
+	defer mk.inputMutex.Unlock()
+	if hid == 0 || !mk.forwarded[hid] {
+		return nil
+	}
+	mode, _, _ := config.GetKeyRepeat(hid)
+	if mode == config.KeyRepeatPassthrough {
+		return mk.Ctrl.KeyDown(hid)
+	}
+	return nil // ignore 直接丢弃, software 由 startKeyRepeat 自行生成
+}
+
+// startKeyRepeat 在 software 模式下为刚转发的按键启动软件重复，调用时需持有 inputMutex
+func (mk *MacroMouseKeyboard) startKeyRepeat(hid byte) {
+	mode, delay, interval := config.GetKeyRepeat(hid)
+	if mode != config.KeyRepeatSoftware {
+		return
+	}
+	stop := make(chan struct{})
+	mk.repeatMutex.Lock()
+	if old, ok := mk.repeating[hid]; ok {
+		close(old)
+	}
+	mk.repeating[hid] = stop
+	mk.repeatMutex.Unlock()
+
+	go func() {
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		select {
+		case <-stop:
+			return
+		case <-timer.C:
+		}
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for mk.repeatKey(hid, stop) {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// repeatKey 重复一次按键，和按键事件一样持有 inputMutex，按键已松开或被释放时返回 false
+func (mk *MacroMouseKeyboard) repeatKey(hid byte, stop chan struct{}) bool {
+	mk.inputMutex.Lock()
+	defer mk.inputMutex.Unlock()
+	select {
+	case <-stop:
+		return false
+	default:
+	}
+	if !mk.forwarded[hid] {
+		return false
+	}
+	// 目标端收到同样的报告不会重复，需要先抬起再按下
+	mk.Ctrl.KeyUp(hid)
+	mk.Ctrl.KeyDown(hid)
+	return true
+}
+
+// stopKeyRepeat 停止按键的软件重复
+func (mk *MacroMouseKeyboard) stopKeyRepeat(hid byte) {
+	mk.repeatMutex.Lock()
+	defer mk.repeatMutex.Unlock()
+	if stop, ok := mk.repeating[hid]; ok {
+		close(stop)
+		delete(mk.repeating, hid)
+	}
+}
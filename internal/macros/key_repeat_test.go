@@ -0,0 +1,43 @@
+package macros
+
+import (
+	"input2com/internal/clock"
+	"input2com/internal/config"
+	"reflect"
+	"testing"
+)
+
+func TestKeyRepeatOnlyForwardedKeys(t *testing.T) {
+	old := config.Cfg
+	defer func() { config.Cfg = old }()
+	config.Cfg = &config.Config{KeyRepeat: config.KeyRepeatConfig{KeyRepeatRule: config.KeyRepeatRule{Mode: config.KeyRepeatPassthrough}}}
+	if err := LoadLeader(config.LeaderConfig{Key: "b"}); err != nil {
+		t.Fatal(err)
+	}
+	defer LoadLeader(config.LeaderConfig{})
+	const keyA, keyB = 30, 48 // evdev KEY_A(HID 0x04)、KEY_B
+
+	ctrl := &fakeCtrl{}
+	mk := NewMacroMouseKeyboardWithClock(ctrl, clock.Real)
+	expect := func(want ...string) {
+		t.Helper()
+		if got := ctrl.Calls(); !reflect.DeepEqual(got, want) {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	}
+	mk.KeyRepeat(keyA) // 没有按下
+	mk.KeyDown(keyA, "kbd")
+	mk.KeyRepeat(keyA)
+	mk.KeyUp(keyA, "kbd")
+	expect("kdown 0x4", "kdown 0x4", "kup 0x4")
+
+	mk.KeyDown(keyA, "kbd")
+	mk.ReleaseDevice("kbd") // 设备移除、切换方案时提前释放
+	mk.KeyRepeat(keyA)
+	expect("kdown 0x4", "kup 0x4")
+
+	mk.KeyDown(keyB, "kbd") // leader 键被吞掉
+	mk.KeyRepeat(keyB)
+	mk.KeyUp(keyB, "kbd")
+	expect()
+}
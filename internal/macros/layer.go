@@ -0,0 +1,295 @@
+package macros
+
+import (
+	"fmt"
+	"input2com/internal/config"
+	"input2com/internal/input"
+	"input2com/internal/logger"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// 层切换动作，写在绑定中，如 "mo(fn)"
+const (
+	LayerMomentary = "mo" // 按住时激活
+	LayerToggle    = "tg" // 按下切换激活状态
+	LayerOneShot   = "os" // 只对下一个按键生效
+)
+
+var layerActionRe = regexp.MustCompile(`^(mo|tg|os)\(([\w-]+)\)$`)
+
+// ParseLayerAction 解析层切换绑定，不是层切换时 ok 为 false
+func ParseLayerAction(binding string) (action, layer string, ok bool) {
+	m := layerActionRe.FindStringSubmatch(strings.TrimSpace(binding))
+	if m == nil {
+		return "", "", false
+	}
+	return m[1], m[2], true
+}
+
+// LayerStatus 层的定义和激活状态
+type LayerStatus struct {
+	config.LayerConfig
+	Active    bool `json:"active"`
+	Momentary int  `json:"momentary"` // 按住中的 mo 键数量
+	Toggled   bool `json:"toggled"`
+	OneShot   bool `json:"one_shot"`
+}
+
+var (
+	Layers     []*LayerStatus // 按优先级从低到高排列
+	LayerMutex sync.RWMutex
+)
+
+// LoadLayers 从配置加载层，会清除所有层的激活状态
+func LoadLayers(layers []config.LayerConfig) error {
+	if err := checkLayers(layers); err != nil {
+		return err
+	}
+	result := make([]*LayerStatus, 0, len(layers))
+	for _, l := range layers {
+		result = append(result, &LayerStatus{LayerConfig: l})
+	}
+	LayerMutex.Lock()
+	Layers = result
+	LayerMutex.Unlock()
+	return nil
+}
+
+func checkLayers(layers []config.LayerConfig) error {
+	names := make(map[string]bool)
+	for _, l := range layers {
+		if l.Name == "" || names[l.Name] {
+			return fmt.Errorf("layer name %q is empty or duplicated", l.Name)
+		}
+		names[l.Name] = true
+	}
+	return nil
+}
+
+// findLayer 按名称查找层，调用时需持有锁
+func findLayer(name string) *LayerStatus {
+	for _, l := range Layers {
+		if l.Name == name {
+			return l
+		}
+	}
+	return nil
+}
+
+func (l *LayerStatus) update() {
+	l.Active = l.Momentary > 0 || l.Toggled || l.OneShot
+}
+
+// ActiveLayers 返回当前激活的层名，优先级从高到低
+func ActiveLayers() []string {
+	LayerMutex.RLock()
+	defer LayerMutex.RUnlock()
+	names := make([]string, 0)
+	for i := len(Layers) - 1; i >= 0; i-- {
+		if Layers[i].Active {
+			names = append(names, Layers[i].Name)
+		}
+	}
+	return names
+}
+
+// SetLayer 通过接口打开或关闭层(与 tg 相同)
+func SetLayer(name string, on bool) error {
+	LayerMutex.Lock()
+	defer LayerMutex.Unlock()
+	l := findLayer(name)
+	if l == nil {
+		return fmt.Errorf("unknown layer %q", name)
+	}
+	l.Toggled = on
+	l.update()
+	return nil
+}
+
+// parseKeyBinding 解析 "key:esc"、"mouse:middle" 形式的绑定，表示在目标端按下该键
+func parseKeyBinding(binding string) (code byte, isMouse bool, ok bool) {
+	if !strings.HasPrefix(binding, "key:") && !strings.HasPrefix(binding, "mouse:") {
+		return 0, false, false
+	}
+	return input.ParseKey(binding)
+}
+
+// IsValidBinding 判断绑定是否为已存在的宏、层切换、按键或 tap-hold
+func IsValidBinding(binding string) bool {
+	return isValidBinding(currentMacros(), binding)
+}
+
+// IsValidBindingLocked 同 IsValidBinding，调用时需持有 KeyboarddictMutex(如修改键盘绑定的接口)
+func IsValidBindingLocked(binding string) bool {
+	return isValidBinding(Macros, binding)
+}
+
+func isValidBinding(macros map[string]Macro, binding string) bool {
+	if _, layer, ok := ParseLayerAction(binding); ok {
+		LayerMutex.RLock()
+		defer LayerMutex.RUnlock()
+		return findLayer(layer) != nil
+	}
+	if name, ok := ParseProfileAction(binding); ok {
+		return isValidProfile(name)
+	}
+	if tap, hold, ok := parseTapHold(macros, binding); ok {
+		return isValidBinding(macros, tap) && isValidBinding(macros, hold)
+	}
+	if _, _, ok := parseKeyBinding(binding); ok {
+		return true
+	}
+	_, _, _, err := resolveMacro(macros, binding)
+	return err == nil
+}
+
+// applyLayerAction 处理层切换键的按下和松开
+func applyLayerAction(action, name string, down bool) {
+	LayerMutex.Lock()
+	defer LayerMutex.Unlock()
+	l := findLayer(name)
+	if l == nil {
+		logger.Logger.Warnf("未知的层: %s", name)
+		return
+	}
+	switch action {
+	case LayerMomentary:
+		if down {
+			l.Momentary++
+		} else if l.Momentary > 0 {
+			l.Momentary--
+		}
+	case LayerToggle:
+		if down {
+			l.Toggled = !l.Toggled
+		}
+	case LayerOneShot:
+		if down {
+			l.OneShot = true
+		}
+	}
+	l.update()
+	logger.Logger.Debugf("层 %s: %s %t, 激活: %t", name, action, down, l.Active)
+}
+
+// resolveBinding 从最高的激活层往下查找绑定，都没有时使用设备(鼠标)或全局(键盘)配置。
+// consume 为 true 时消耗掉一次性层
+func resolveBinding(devName string, isMouse bool, code byte, consume bool) (string, bool) {
+	LayerMutex.Lock()
+	binding, found := "", false
+	for i := len(Layers) - 1; i >= 0 && !found; i-- {
+		l := Layers[i]
+		if !l.Active {
+			continue
+		}
+		if isMouse {
+			binding, found = l.Mouse[code]
+		} else {
+			binding, found = l.Keyboard[code]
+		}
+	}
+	if consume {
+		if _, _, isLayer := ParseLayerAction(binding); !isLayer {
+			for _, l := range Layers {
+				if l.OneShot {
+					l.OneShot = false
+					l.update()
+				}
+			}
+		}
+	}
+	LayerMutex.Unlock()
+	if found {
+		return binding, true
+	}
+	if isMouse {
+		MousedictMutex.RLock()
+		defer MousedictMutex.RUnlock()
+		binding, found = MouseConfigDict[strings.ToLower(devName)][code]
+		return binding, found
+	}
+	KeyboarddictMutex.RLock()
+	defer KeyboarddictMutex.RUnlock()
+	binding, found = KeyboardConfigDict[code]
+	return binding, found
+}
+
+// bindingKey 标识某个输入源的一个按键
+type bindingKey struct {
+	source  string
+	isMouse bool
+	code    byte
+}
+
+// pressBinding 解析按下按键的绑定并记录下来，松开时使用同一个绑定。
+// 返回 false 表示没有绑定，按键应直接转发
+func (mk *MacroMouseKeyboard) pressBinding(devName string, isMouse bool, code byte) bool {
+	key := bindingKey{strings.ToLower(devName), isMouse, code}
+	binding, _ := resolveBinding(devName, isMouse, code, true)
+	mk.bindingMutex.Lock()
+	mk.bindings[key] = binding
+	mk.bindingMutex.Unlock()
+	return mk.applyBinding(key, binding, true)
+}
+
+// releaseBinding 使用按键按下时解析的绑定，没有记录时重新解析
+func (mk *MacroMouseKeyboard) releaseBinding(devName string, isMouse bool, code byte) bool {
+	key := bindingKey{strings.ToLower(devName), isMouse, code}
+	mk.bindingMutex.Lock()
+	binding, ok := mk.bindings[key]
+	delete(mk.bindings, key)
+	mk.bindingMutex.Unlock()
+	if !ok {
+		binding, _ = resolveBinding(devName, isMouse, code, false)
+	}
+	return mk.applyBinding(key, binding, false)
+}
+
+// applyBinding 以 key 的按下/松开执行绑定：层切换、方案切换、目标端按键或宏，宏的调用与 key 关联
+func (mk *MacroMouseKeyboard) applyBinding(key bindingKey, binding string, down bool) bool {
+	if action, layer, ok := ParseLayerAction(binding); ok {
+		applyLayerAction(action, layer, down)
+		return true
+	}
+	if name, ok := ParseProfileAction(binding); ok {
+		if down {
+			go func() { // 切换时需要等待当前的按键事件处理完
+				if err := mk.SwitchProfile(name); err != nil {
+					logger.Logger.Warnf("切换方案失败: %v", err)
+				}
+			}()
+		}
+		return true
+	}
+	if code, isMouse, ok := parseKeyBinding(binding); ok {
+		switch {
+		case isMouse && down:
+			mk.forwardBtnDown(code)
+		case isMouse:
+			mk.forwardBtnUp(code)
+		case down:
+			mk.Ctrl.KeyDown(code)
+		default:
+			mk.Ctrl.KeyUp(code)
+		}
+		return true
+	}
+	if !down {
+		return mk.releaseMacro(key)
+	}
+	name, macro, args, err := resolveMacro(currentMacros(), binding)
+	if err != nil {
+		if binding != "" {
+			logger.Logger.Warnf("绑定 %q 无效: %v", binding, err)
+		}
+		return false
+	}
+	if !profileAllows(name) {
+		logger.Logger.Warnf("当前方案不能使用宏 %s", name)
+		return false
+	}
+	mk.startMacro(key, name, macro, args)
+	return true
+}
@@ -0,0 +1,97 @@
+package macros
+
+import (
+	"context"
+	"input2com/internal/clock"
+	"input2com/internal/config"
+	"slices"
+	"sync"
+	"testing"
+)
+
+func TestIsValidBindingDuringReload(t *testing.T) {
+	NewMacroMouseKeyboardWithClock(&fakeCtrl{}, clock.Real) // 注册内置宏
+	src := macroSource{kindFile, "test.yaml"}
+	defer setSource(src, nil)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ { // 和热重载一样替换 Macros
+			setSource(src, map[string]Macro{"test_reload": {Name: "test_reload", Run: func(context.Context, *MacroMouseKeyboard) {}}})
+			setSource(src, nil)
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		IsValidBinding("test_reload")
+	}
+	wg.Wait()
+	if !IsValidBinding("click_repeat(interval=50ms)") || IsValidBinding("no_such_macro") {
+		t.Error("wrong result for built-in or unknown macro")
+	}
+}
+
+// withLayers 临时加载层，测试结束后清除
+func withLayers(t *testing.T, layers ...config.LayerConfig) {
+	if err := LoadLayers(layers); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { LoadLayers(nil) })
+}
+
+func TestLayerRelease(t *testing.T) {
+	fn := config.LayerConfig{Name: "fn", Keyboard: map[byte]string{0x04: "key:esc"}}
+	tests := []struct {
+		name    string
+		binding string // capslock 的绑定
+		run     func(mk *MacroMouseKeyboard)
+		want    []string
+	}{
+		{"momentary released before the key", "mo(fn)", func(mk *MacroMouseKeyboard) {
+			mk.KeyDown(evCaps, "kbd")
+			mk.KeyDown(evA, "kbd")
+			mk.KeyUp(evCaps, "kbd")
+			mk.KeyUp(evA, "kbd")
+		}, []string{"0ms kdown 0x29", "0ms kup 0x29"}},
+		{"momentary pressed while the key is held", "mo(fn)", func(mk *MacroMouseKeyboard) {
+			mk.KeyDown(evA, "kbd")
+			mk.KeyDown(evCaps, "kbd")
+			mk.KeyUp(evA, "kbd")
+			mk.KeyUp(evCaps, "kbd")
+		}, []string{"0ms kdown 0x4", "0ms kup 0x4"}},
+		{"toggle on and off", "tg(fn)", func(mk *MacroMouseKeyboard) {
+			mk.KeyDown(evCaps, "kbd")
+			mk.KeyUp(evCaps, "kbd")
+			mk.KeyDown(evA, "kbd")
+			mk.KeyUp(evA, "kbd")
+			mk.KeyDown(evCaps, "kbd")
+			mk.KeyUp(evCaps, "kbd")
+			mk.KeyDown(evA, "kbd")
+			mk.KeyUp(evA, "kbd")
+		}, []string{"0ms kdown 0x29", "0ms kup 0x29", "0ms kdown 0x4", "0ms kup 0x4"}},
+		{"toggled off while the key is held", "tg(fn)", func(mk *MacroMouseKeyboard) {
+			mk.KeyDown(evCaps, "kbd")
+			mk.KeyUp(evCaps, "kbd")
+			mk.KeyDown(evA, "kbd")
+			mk.KeyDown(evCaps, "kbd")
+			mk.KeyUp(evCaps, "kbd")
+			mk.KeyUp(evA, "kbd")
+		}, []string{"0ms kdown 0x29", "0ms kup 0x29"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withConfig(t, &config.Config{})
+			withLayers(t, fn)
+			bindKeys(t, map[byte]string{0x39: tt.binding})
+			mk, ctrl, _ := fakeKB()
+			tt.run(mk)
+			expectCalls(t, ctrl, tt.want...)
+			if active := ActiveLayers(); len(active) > 0 {
+				t.Errorf("layers still active: %v", active)
+			}
+			if slices.ContainsFunc(Layers, func(l *LayerStatus) bool { return l.Momentary != 0 }) {
+				t.Error("momentary count not back to zero")
+			}
+		})
+	}
+}
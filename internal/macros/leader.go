@@ -0,0 +1,166 @@
+package macros
+
+import (
+	"fmt"
+	"input2com/internal/clock"
+	"input2com/internal/config"
+	"input2com/internal/input"
+	"input2com/internal/logger"
+	"strings"
+	"sync"
+)
+
+// LeaderSequence 解析后的 leader 序列
+type LeaderSequence struct {
+	Keys  []string `json:"keys"`
+	Macro string   `json:"macro"`
+	keys  []comboKey
+}
+
+var (
+	leaderKey       *comboKey // 为 nil 时不启用 leader
+	LeaderSequences []*LeaderSequence
+	LeaderMutex     sync.RWMutex
+)
+
+// LoadLeader 从配置加载 leader 键和序列，需在宏加载之后调用
+func LoadLeader(cfg config.LeaderConfig) error {
+	var key *comboKey
+	if cfg.Key != "" {
+		code, isMouse, ok := input.ParseKey(cfg.Key)
+		if !ok {
+			return fmt.Errorf("unknown leader key %q", cfg.Key)
+		}
+		key = &comboKey{isMouse, code}
+	}
+	seqs := make([]*LeaderSequence, 0, len(cfg.Sequences))
+	for _, s := range cfg.Sequences {
+		if len(s.Keys) == 0 {
+			return fmt.Errorf("leader sequence for %q is empty", s.Macro)
+		}
+		if _, ok := LookupMacro(s.Macro); !ok {
+			return fmt.Errorf("leader sequence %v: unknown macro %q", s.Keys, s.Macro)
+		}
+		seq := &LeaderSequence{Keys: s.Keys, Macro: s.Macro}
+		for _, name := range s.Keys {
+			code, isMouse, ok := input.ParseKey(name)
+			if !ok {
+				return fmt.Errorf("leader sequence %v: unknown key %q", s.Keys, name)
+			}
+			seq.keys = append(seq.keys, comboKey{isMouse, code})
+		}
+		seqs = append(seqs, seq)
+	}
+	LeaderMutex.Lock()
+	leaderKey, LeaderSequences = key, seqs
+	LeaderMutex.Unlock()
+	return nil
+}
+
+// matchLeader 查找以 typed 开头的序列，exact 为完全相同的序列
+func matchLeader(typed []comboKey) (prefix bool, exact *LeaderSequence) {
+	LeaderMutex.RLock()
+	defer LeaderMutex.RUnlock()
+	for _, s := range LeaderSequences {
+		if len(s.keys) < len(typed) {
+			continue
+		}
+		match := true
+		for i, k := range typed {
+			match = match && s.keys[i] == k
+		}
+		if !match {
+			continue
+		}
+		prefix = true
+		if len(s.keys) == len(typed) && exact == nil {
+			exact = s
+		}
+	}
+	return prefix, exact
+}
+
+// leaderState 正在输入的 leader 序列
+type leaderState struct {
+	typed []comboKey
+	timer clock.Timer
+}
+
+// processLeader leader 处理，调用时需持有 inputMutex。
+// leader 激活期间的按键(包括它们的松开)都不转发
+func (mk *MacroMouseKeyboard) processLeader(ev keyEvent) error {
+	if !ev.down {
+		if mk.leaderSwallow[ev.comboKey()] {
+			delete(mk.leaderSwallow, ev.comboKey())
+			return nil
+		}
+		if macro, ok := mk.leaderHeld[ev.comboKey()]; ok {
+			delete(mk.leaderHeld, ev.comboKey())
+			mk.runBinding(ev, macro) // 序列最后一个键松开时结束宏
+			return nil
+		}
+		return mk.processCombo(ev)
+	}
+	if mk.leader == nil {
+		LeaderMutex.RLock()
+		isLeader := leaderKey != nil && *leaderKey == ev.comboKey()
+		LeaderMutex.RUnlock()
+		if !isLeader {
+			return mk.processCombo(ev)
+		}
+		mk.leaderSwallow[ev.comboKey()] = true
+		state := &leaderState{}
+		mk.leader = state
+		state.timer = clock.AfterFunc(mk.Clock, config.GetLeaderTimeout(), func() {
+			mk.inputMutex.Lock()
+			defer mk.inputMutex.Unlock()
+			if mk.leader == state {
+				logger.Logger.Debugf("leader 序列超时")
+				mk.leader = nil
+			}
+		})
+		logger.Logger.Debugf("leader 激活")
+		return nil
+	}
+	state := mk.leader
+	state.typed = append(state.typed, ev.comboKey())
+	prefix, exact := matchLeader(state.typed)
+	if exact != nil {
+		state.timer.Stop()
+		mk.leader = nil
+		logger.Logger.Infof("leader 序列 %s -> %s", strings.Join(exact.Keys, " "), exact.Macro)
+		mk.leaderHeld[ev.comboKey()] = exact.Macro
+		mk.runBinding(ev, exact.Macro)
+		return nil
+	}
+	mk.leaderSwallow[ev.comboKey()] = true
+	if !prefix {
+		state.timer.Stop()
+		mk.leader = nil
+		logger.Logger.Debugf("没有匹配的 leader 序列")
+	}
+	return nil
+}
+
+// LeaderTriggers 返回各个宏的 leader 序列，如 "greet" -> ["g c"]
+func LeaderTriggers() map[string][]string {
+	LeaderMutex.RLock()
+	defer LeaderMutex.RUnlock()
+	result := make(map[string][]string)
+	for _, s := range LeaderSequences {
+		result[s.Macro] = append(result[s.Macro], strings.Join(s.Keys, " "))
+	}
+	return result
+}
+
+// MacrosWithTriggers 返回宏列表的副本，带上各个宏的 leader 序列
+func MacrosWithTriggers() map[string]Macro {
+	triggers := LeaderTriggers()
+	macros := currentMacros()
+	result := make(map[string]Macro, len(macros))
+	for name, m := range macros {
+		m.Leader = triggers[name]
+		result[name] = m
+	}
+	return result
+}
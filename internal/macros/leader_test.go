@@ -0,0 +1,59 @@
+package macros
+
+import (
+	"input2com/internal/clock"
+	"input2com/internal/config"
+	"testing"
+	"time"
+)
+
+func TestLeader(t *testing.T) {
+	registerTestMacros(t, `
+- name: test_leader
+  steps: [{press: a}]
+  on_release: [{release: a}]
+`)
+	if err := LoadLeader(config.LeaderConfig{Key: "b", Sequences: []config.LeaderSequence{{Keys: []string{"j", "k"}, Macro: "test_leader"}}}); err != nil {
+		t.Fatal(err)
+	}
+	defer LoadLeader(config.LeaderConfig{})
+	tap := func(mk *MacroMouseKeyboard, code uint16) {
+		mk.KeyDown(code, "kbd")
+		mk.KeyUp(code, "kbd")
+	}
+	tests := []struct {
+		name string
+		run  func(mk *MacroMouseKeyboard, ctrl *fakeCtrl, fc *clock.Fake)
+		want []string
+	}{
+		{"sequence runs the macro until the last key is released", func(mk *MacroMouseKeyboard, ctrl *fakeCtrl, fc *clock.Fake) {
+			tap(mk, evB)
+			tap(mk, evJ)
+			mk.KeyDown(evK, "kbd")
+			ctrl.waitCall(t, "0ms kdown 0x4")
+			mk.KeyUp(evK, "kbd")
+			ctrl.waitCall(t, "0ms kup 0x4")
+		}, []string{"0ms kdown 0x4", "0ms kup 0x4"}},
+		{"timeout forwards keys again", func(mk *MacroMouseKeyboard, ctrl *fakeCtrl, fc *clock.Fake) {
+			tap(mk, evB)
+			fc.Advance(time.Second)
+			tap(mk, evJ)
+		}, []string{"1000ms kdown 0xd", "1000ms kup 0xd"}},
+		{"key outside every sequence cancels", func(mk *MacroMouseKeyboard, ctrl *fakeCtrl, fc *clock.Fake) {
+			tap(mk, evB)
+			tap(mk, evA) // 被吞掉，leader 结束
+			tap(mk, evJ)
+			fc.Advance(time.Second) // 计时已停止
+		}, []string{"0ms kdown 0xd", "0ms kup 0xd"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mk, ctrl, fc := fakeKB()
+			tt.run(mk, ctrl, fc)
+			expectCalls(t, ctrl, tt.want...)
+			if mk.leader != nil || len(mk.leaderSwallow) != 0 || len(mk.leaderHeld) != 0 {
+				t.Error("leader state left behind")
+			}
+		})
+	}
+}
@@ -1,30 +1,55 @@
 package macros
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
+	"input2com/internal/clock"
 	"input2com/internal/config"
 	"input2com/internal/input"
 	"input2com/internal/logger"
-	"io/fs"
 	"math"
 	"math/rand"
 	"os"
-	"path/filepath"
 	"strings"
 	"sync"
 	"time"
 )
 
 type MacroMouseKeyboard struct {
-	MouseBtnArgs    map[string]map[byte]chan bool
-	KeyArgs         map[byte]chan bool
 	Ctrl            MouseCtrl
-	Macros          map[string]Macro
+	Clock           clock.Clock // 宏的计时，测试时可以换成 clock.Fake
 	PreData         [5]int32
 	AimData         [5]int32
 	LastTriggerTime int64
 	LastDecTime     time.Time
 	A               bool
+
+	repeating   map[byte]chan struct{} // software模式下正在重复的按键
+	repeatMutex sync.Mutex
+
+	pressed      map[string]*pressedState // 各输入源当前按下的按键
+	stale        map[string]*pressedState // 切换方案时已释放但仍按着的按键
+	pressedMutex sync.Mutex
+
+	bindings     map[bindingKey]string // 按下时解析的绑定，松开时使用
+	bindingMutex sync.Mutex
+
+	inputMutex    sync.Mutex            // 按键事件按顺序经过 leader、组合键、tap-hold 处理后再分发
+	leader        *leaderState          // 正在输入的 leader 序列
+	leaderSwallow map[comboKey]bool     // 需要吞掉松开事件的按键
+	leaderHeld    map[comboKey]string   // leader 序列最后一个键 -> 触发的宏，松开时结束宏
+	combo         *comboPending         // 等待按全的组合键
+	combosActive  []*activeCombo        // 已触发、按键还没全部松开的组合键
+	tapHold       *tapHoldPending       // 等待判定的 tap-hold 按键
+	holding       map[bindingKey]string // 已判定为长按的 tap-hold 按键 -> 长按绑定
+	forwarded     map[byte]bool         // 已转发给目标端还没有松开的键盘按键(HID)，只有这些按键会重复
+	sent          map[bindingKey]bool   // PressKey 按下还没有松开的按键
+
+	invocations      map[uint64]*Invocation     // 正在运行的宏调用
+	held             map[bindingKey]*Invocation // 按键 -> 按下时启动的调用，松开时停止
+	lastInvocationID uint64
+	invocationMutex  sync.Mutex
 }
 type MouseCtrl interface {
 	MouseBtnDown(keyCode byte) error
@@ -35,6 +60,46 @@ type MouseCtrl interface {
 	KeyUp(keyCode byte) error
 	LockMouse(Button int, lock int) error
 	Click(i int) error
+	// MouseBtnMask 目标端可以输出的鼠标按键(input.MouseBtnXxx 位掩码)，其余按键只能用于触发宏
+	MouseBtnMask() byte
+}
+
+// HWheelCtrl 可选接口，支持横向滚轮的控制器实现(CH9329 的鼠标报告没有横向滚轮)
+type HWheelCtrl interface {
+	MouseHWheel(steps int32) error
+}
+
+func (mk *MacroMouseKeyboard) SupportsHWheel() bool {
+	_, ok := unwrapCtrl[HWheelCtrl](mk.Ctrl)
+	return ok
+}
+
+// MouseHWheel 横向滚动，控制器不支持时丢弃
+func (mk *MacroMouseKeyboard) MouseHWheel(steps int32) error {
+	hWheel, ok := unwrapCtrl[HWheelCtrl](mk.Ctrl)
+	if !ok {
+		return nil
+	}
+	return hWheel.MouseHWheel(steps)
+}
+
+// HiResWheelCtrl 可选接口，支持高精度滚轮(120单位/格)的控制器实现，其他控制器只能按整格滚动
+type HiResWheelCtrl interface {
+	MouseWheelHiRes(vertical, horizontal int32) error
+}
+
+func (mk *MacroMouseKeyboard) SupportsHiResWheel() bool {
+	_, ok := unwrapCtrl[HiResWheelCtrl](mk.Ctrl)
+	return ok
+}
+
+// MouseWheelHiRes 高精度滚动，控制器不支持时丢弃
+func (mk *MacroMouseKeyboard) MouseWheelHiRes(vertical, horizontal int32) error {
+	hiRes, ok := unwrapCtrl[HiResWheelCtrl](mk.Ctrl)
+	if !ok {
+		return nil
+	}
+	return hiRes.MouseWheelHiRes(vertical, horizontal)
 }
 
 func (mk *MacroMouseKeyboard) SetAimData(x, y, x2, y2, timeStamp int32) error {
@@ -42,6 +107,28 @@ func (mk *MacroMouseKeyboard) SetAimData(x, y, x2, y2, timeStamp int32) error {
 	mk.AimData = [5]int32{x, y, x2, y2, timeStamp}
 	return nil
 }
+
+// scheduler 按宏的时钟安排一连串等待，见 clock.Scheduler
+func (mk *MacroMouseKeyboard) scheduler() *clock.Scheduler {
+	return clock.NewScheduler(mk.Clock)
+}
+
+// sleep 在宏的时钟上等待 d
+func (mk *MacroMouseKeyboard) sleep(d time.Duration) {
+	clock.Sleep(mk.Clock, d)
+}
+
+// waitRelease 等待 ctx 结束(按键松开或被取消)，等待期间不使用时钟
+func (mk *MacroMouseKeyboard) waitRelease(ctx context.Context) {
+	clock.Idle(mk.Clock)
+	<-ctx.Done()
+}
+
+// wait 在宏的时钟上等待 d，ctx 结束(按键松开或被取消)时提前返回 false
+func (mk *MacroMouseKeyboard) wait(ctx context.Context, d time.Duration) bool {
+	return mk.scheduler().Wait(d, ctx.Done())
+}
+
 func clamp(value, min, max int32) int32 {
 	if value < min {
 		return min
@@ -55,7 +142,13 @@ func clamp(value, min, max int32) int32 {
 type Macro struct {
 	Name        string                               `json:"name"`
 	Description string                               `json:"description"`
-	Fn          func(*MacroMouseKeyboard, chan bool) `json:"-"`
+	Fn          func(*MacroMouseKeyboard, chan bool) `json:"-"` // 旧式宏，松开或取消时向管道写入，见 runFn
+	// Run 与 Fn 二选一，ctx 在按键松开或调用被取消时结束
+	Run    func(ctx context.Context, mk *MacroMouseKeyboard) `json:"-"`
+	Params []Param                                           `json:"params,omitempty"` // 参数定义，Run 中通过 ArgsFromContext 读取
+	Leader []string                                          `json:"leader,omitempty"` // 触发该宏的 leader 序列
+
+	def *MacroDefinition // 声明式宏的定义，被其他宏调用时直接执行其中的步骤
 }
 
 var (
@@ -67,16 +160,27 @@ var (
 )
 var Macros = make(map[string]Macro)
 
-func downDragMacro(recoils []*Recoil, multiplier float64) func(mk *MacroMouseKeyboard, ch chan bool) {
-	return func(mk *MacroMouseKeyboard, ch chan bool) {
+// BindDevice 为新插入的设备准备鼠标配置，已有的配置(包括拔出前通过接口修改的)保持不变
+func BindDevice(devName string) {
+	MousedictMutex.Lock()
+	defer MousedictMutex.Unlock()
+	name := strings.ToLower(devName)
+	if _, ok := MouseConfigDict[name]; !ok {
+		MouseConfigDict[name] = make(map[byte]string)
+	}
+}
+
+func downDragMacro(recoils []*Recoil, multiplier float64) func(ctx context.Context, mk *MacroMouseKeyboard) {
+	return func(ctx context.Context, mk *MacroMouseKeyboard) {
 		mk.Ctrl.MouseBtnDown(input.MouseBtnLeft)
 		defer mk.Ctrl.MouseBtnUp(input.MouseBtnLeft)
+		sched := mk.scheduler()
 
 		// 执行所有recoil移动
 		for _, recoil := range recoils {
 
 			select {
-			case <-ch:
+			case <-ctx.Done():
 				return // 收到释放信号，立即返回
 			default:
 				if recoil.Count > 0 {
@@ -85,32 +189,37 @@ func downDragMacro(recoils []*Recoil, multiplier float64) func(mk *MacroMouseKey
 					sleepDuration := time.Duration(actualStepTime * float64(time.Second))
 					for i := 0; i < int(moveCnt); i++ {
 						select {
-						case <-ch:
+						case <-ctx.Done():
 							return // 收到释放信号，立即返回
 						default:
 							//fmt.Println(recoil.Dx, recoil.Dy, sleepDuration)
 							mk.Ctrl.MouseMove(recoil.Dx, recoil.Dy, 0)
-							time.Sleep(sleepDuration)
+							if !sched.Wait(sleepDuration, ctx.Done()) {
+								return
+							}
 						}
 					}
 				} else {
 					mk.Ctrl.MouseMove(recoil.Dx, recoil.Dy, 0)
 					//fmt.Println(1, recoil.Dx, recoil.Dy, recoil.Count)
-					time.Sleep(time.Duration(recoil.RelativeTime * float64(time.Second)))
+					if !sched.Wait(time.Duration(recoil.RelativeTime*float64(time.Second)), ctx.Done()) {
+						return
+					}
 				}
 			}
 		}
 		// recoils序列执行完毕，等待释放信号
-		<-ch
+		mk.waitRelease(ctx)
 	}
 }
-func downDragMacroWithRight(recoils []*Recoil, multiplier float64) func(mk *MacroMouseKeyboard, ch chan bool) {
-	return func(mk *MacroMouseKeyboard, ch chan bool) {
+func downDragMacroWithRight(recoils []*Recoil, multiplier float64) func(ctx context.Context, mk *MacroMouseKeyboard) {
+	return func(ctx context.Context, mk *MacroMouseKeyboard) {
 		mk.Ctrl.MouseBtnDown(input.MouseBtnLeft)
 		defer mk.Ctrl.MouseBtnUp(input.MouseBtnLeft)
+		sched := mk.scheduler()
 		for _, recoil := range recoils {
 			select {
-			case <-ch:
+			case <-ctx.Done():
 				return
 			default:
 				if mk.Ctrl.IsMouseBtnPressed(input.MouseBtnRight) {
@@ -119,44 +228,38 @@ func downDragMacroWithRight(recoils []*Recoil, multiplier float64) func(mk *Macr
 						actualStepTime := recoil.RelativeTime / moveCnt
 						sleepDuration := time.Duration(actualStepTime * float64(time.Second))
 						for i := 0; i < int(moveCnt); i++ {
-							select {
-							case <-ch:
+							if !sched.Wait(sleepDuration, ctx.Done()) {
 								return
-							case <-time.After(sleepDuration):
-								mk.Ctrl.MouseMove(recoil.Dx, recoil.Dy, 0)
 							}
+							mk.Ctrl.MouseMove(recoil.Dx, recoil.Dy, 0)
 						}
 					} else {
-						select {
-						case <-ch:
+						if !sched.Wait(time.Duration(recoil.RelativeTime*float64(time.Second)), ctx.Done()) {
 							return
-						case <-time.After(time.Duration(recoil.RelativeTime * float64(time.Second))):
-							mk.Ctrl.MouseMove(recoil.Dx, recoil.Dy, 0)
 						}
+						mk.Ctrl.MouseMove(recoil.Dx, recoil.Dy, 0)
 					}
 				} else {
-					select {
-					case <-ch:
-						return
-					case <-time.After(time.Duration(recoil.RelativeTime * float64(time.Second))):
-						// 不执行移动，只等待
+					if !sched.Wait(time.Duration(recoil.RelativeTime*float64(time.Second)), ctx.Done()) {
+						return // 不执行移动，只等待
 					}
 				}
 			}
 		}
 		// recoils序列执行完毕，等待释放信号
-		<-ch
+		mk.waitRelease(ctx)
 	}
 }
 
 // ---------- 主函数 ----------
-func downDragMacroWithForward(recoils []*Recoil, multiplier float64) func(mk *MacroMouseKeyboard, ch chan bool) {
-	return func(mk *MacroMouseKeyboard, ch chan bool) {
+func downDragMacroWithForward(recoils []*Recoil, multiplier float64) func(ctx context.Context, mk *MacroMouseKeyboard) {
+	return func(ctx context.Context, mk *MacroMouseKeyboard) {
 		mk.Ctrl.MouseBtnDown(input.MouseBtnLeft)
 		defer mk.Ctrl.MouseBtnUp(input.MouseBtnLeft)
+		sched := mk.scheduler()
 		for _, recoil := range recoils {
 			select {
-			case <-ch:
+			case <-ctx.Done():
 				return
 			default:
 				if mk.Ctrl.IsMouseBtnPressed(input.MouseBtnForward) {
@@ -166,28 +269,26 @@ func downDragMacroWithForward(recoils []*Recoil, multiplier float64) func(mk *Ma
 						sleepDuration := time.Duration(actualStepTime * float64(time.Second))
 						for i := 0; i < int(moveCnt); i++ {
 							select {
-							case <-ch:
+							case <-ctx.Done():
 								return // 收到释放信号，立即返回
 							default:
 								mk.Ctrl.MouseMove(recoil.Dx, recoil.Dx, 0)
-								if sleepDuration > 0 {
-									select {
-									case <-ch:
-										return
-									case <-time.After(sleepDuration):
-									}
+								if sleepDuration > 0 && !sched.Wait(sleepDuration, ctx.Done()) {
+									return
 								}
 							}
 						}
 					} else {
 						mk.Ctrl.MouseMove(recoil.Dx, recoil.Dy, 0)
-						time.Sleep(time.Duration(recoil.RelativeTime * float64(time.Second)))
+						if !sched.Wait(time.Duration(recoil.RelativeTime*float64(time.Second)), ctx.Done()) {
+							return
+						}
 					}
 				}
 			}
 		}
 		// recoils序列执行完毕，等待释放信号
-		<-ch
+		mk.waitRelease(ctx)
 	}
 }
 func easeInOutCubic(t float64) float64 {
@@ -196,27 +297,6 @@ func easeInOutCubic(t float64) float64 {
 	}
 	return 1 - math.Pow(-2*t+2, 3)/2
 }
-func loadPlugins() {
-	pluginsDir := "plugins"
-	entries, err := os.ReadDir(pluginsDir)
-	if err != nil {
-		logger.Logger.Warnf("Failed to read plugins directory: %v", err)
-		return
-	}
-	files := make([]fs.FileInfo, 0, len(entries))
-	for _, entry := range entries {
-		info, err := entry.Info()
-		if err != nil {
-		}
-		files = append(files, info)
-	}
-
-	for _, file := range files {
-		if filepath.Ext(file.Name()) == ".so" {
-			loadPlugin(pluginsDir + "/" + file.Name())
-		}
-	}
-}
 
 type RecoilConfig struct {
 	Name       string    `json:"name"`
@@ -230,176 +310,249 @@ type Recoil struct {
 	Count        int32   `json:"count"`
 }
 
-func NewMacroMouseKeyboard(controler MouseCtrl) *MacroMouseKeyboard {
-	mouseBtnArgs := make(map[string]map[byte]chan bool)
-	keyArgs := make(map[byte]chan bool)
-	mouseBtnArgs["default"] = make(map[byte]chan bool)
-	for i := 0; i < 8; i++ {
-		mouseBtnArgs["default"][byte(1<<i)] = make(chan bool, 1)
-	}
-	mouseBtnArgs["makcu"] = make(map[byte]chan bool)
-	for i := 0; i < 8; i++ {
-		mouseBtnArgs["makcu"][byte(1<<i)] = make(chan bool, 1)
-	}
-	for i := 0; i < 256; i++ {
-		keyArgs[byte(i)] = make(chan bool, 1)
-	}
+// RecoilFile 压枪宏配置文件
+const RecoilFile = "config/macros.json"
 
-	// Load macros from json
-	file, err := os.ReadFile("config/macros.json")
+// LoadRecoils 从 json 加载压枪宏，出错时保留上一次加载的宏
+func LoadRecoils(file string) error {
+	src := macroSource{kindRecoil, file}
+	data, err := os.ReadFile(file)
 	if err != nil {
-		logger.Logger.Fatalf("Failed to read macros file: %v", err)
+		return setLoadError(src, fmt.Errorf("failed to read macros file: %w", err))
 	}
 	var recoils []*RecoilConfig
-	err = json.Unmarshal(file, &recoils)
-	if err != nil {
-		logger.Logger.Fatalf("Failed to unmarshal macros file: %v", err)
+	if err := json.Unmarshal(data, &recoils); err != nil {
+		return setLoadError(src, fmt.Errorf("failed to unmarshal macros file %s: %w", file, err))
 	}
 	logger.Logger.Infof("Loaded recoils: %v", recoils)
 
+	m := make(map[string]Macro, len(recoils)*3+1)
+	profiles := make(map[string]*RecoilConfig, len(recoils))
+	names := make([]string, 0, len(recoils))
 	for _, recoil := range recoils {
-		Macros[recoil.Name] = Macro{
+		profiles[recoil.Name] = recoil
+		names = append(names, recoil.Name)
+		m[recoil.Name] = Macro{
 			Name:        recoil.Name,
 			Description: "压枪宏仅按键按下",
-			Fn:          downDragMacro(recoil.Recoils, recoil.Multiplier),
+			Run:         downDragMacro(recoil.Recoils, recoil.Multiplier),
 		}
-		Macros[recoil.Name+"_withright"] = Macro{
+		m[recoil.Name+"_withright"] = Macro{
 			Name:        recoil.Name + "_withright",
 			Description: "压枪宏右键按下",
-			Fn:          downDragMacroWithRight(recoil.Recoils, recoil.Multiplier),
+			Run:         downDragMacroWithRight(recoil.Recoils, recoil.Multiplier),
 		}
-		Macros[recoil.Name+"_forward"] = Macro{
+		m[recoil.Name+"_forward"] = Macro{
 			Name:        recoil.Name + "_forward",
 			Description: "压枪宏前侧键按下",
-			Fn:          downDragMacroWithForward(recoil.Recoils, recoil.Multiplier),
+			Run:         downDragMacroWithForward(recoil.Recoils, recoil.Multiplier),
+		}
+	}
+	if len(names) > 0 {
+		// 带参数的写法 recoil(ak, with=right)，与上面三个变体等价
+		m["recoil"] = Macro{
+			Name:        "recoil",
+			Description: "压枪宏，profile 为压枪配置",
+			Params: []Param{
+				{Name: "profile", Type: ParamString, Description: "压枪配置", Options: names},
+				{Name: "with", Type: ParamString, Default: "none", Description: "right/forward 时只在右键/前侧键按下时压枪", Options: []string{"none", "right", "forward"}},
+			},
+			Run: func(ctx context.Context, mk *MacroMouseKeyboard) {
+				args := ArgsFromContext(ctx)
+				recoil := profiles[args.String("profile")]
+				fn := downDragMacro
+				switch args.String("with") {
+				case "right":
+					fn = downDragMacroWithRight
+				case "forward":
+					fn = downDragMacroWithForward
+				}
+				fn(recoil.Recoils, recoil.Multiplier)(ctx, mk)
+			},
 		}
 	}
+	setLoadError(src, nil)
+	setSource(src, m)
+	return nil
+}
 
-	Macros["btn_left_hold_autofire"] = Macro{
+// builtinMacros 代码中定义的宏
+func builtinMacros() map[string]Macro {
+	m := make(map[string]Macro)
+	m["btn_left_hold_autofire"] = Macro{
 		Name:        "左键按住连发",
 		Description: "按住左键 = 连点左键",
-		Fn: func(mk *MacroMouseKeyboard, ch chan bool) {
+		Run: func(ctx context.Context, mk *MacroMouseKeyboard) {
+			sched := mk.scheduler()
 			for {
-				select {
-				case <-ch:
+				mk.Ctrl.MouseBtnDown(input.MouseBtnLeft)
+				sched.Wait(8*time.Millisecond, nil) // 按下后总是完整松开
+				mk.Ctrl.MouseBtnUp(input.MouseBtnLeft)
+				if !sched.Wait(8*time.Millisecond, ctx.Done()) {
+					return
+				}
+			}
+		},
+	}
+	m["click_repeat"] = Macro{
+		Name:        "连点",
+		Description: "按住时按 interval 间隔连点 button",
+		Params: []Param{
+			{Name: "interval", Type: ParamDuration, Default: "50ms", Description: "两次点击的间隔"},
+			{Name: "button", Type: ParamKey, Default: "left", Description: "连点的按键"},
+		},
+		Run: func(ctx context.Context, mk *MacroMouseKeyboard) {
+			args := ArgsFromContext(ctx)
+			interval := max(args.Duration("interval"), 2*time.Millisecond)
+			button := args.Key("button")
+			step := Step{Code: button.Code, IsMouse: button.IsMouse}
+			hold := min(defaultTapHold, interval/2)
+			sched := mk.scheduler()
+			for {
+				stepPress(mk, step, true)
+				sched.Wait(hold, nil)
+				stepPress(mk, step, false)
+				if !sched.Wait(interval-hold, ctx.Done()) {
 					return
-				default:
-					mk.Ctrl.MouseBtnDown(input.MouseBtnLeft)
-					time.Sleep(8 * time.Millisecond)
-					mk.Ctrl.MouseBtnUp(input.MouseBtnLeft)
-					time.Sleep(8 * time.Millisecond)
 				}
 			}
 		},
 	}
-	Macros["trigger"] = Macro{
+	m["trigger"] = Macro{
 		Name:        "AI自动扳机",
 		Description: "按住x开启AI自动扳机",
-		Fn: func(mk *MacroMouseKeyboard, ch chan bool) {
+		Run: func(ctx context.Context, mk *MacroMouseKeyboard) {
 			for {
-				select {
-				case <-ch:
+				if math.Abs(float64(mk.AimData[0])/float64(mk.AimData[2])) < 1.1 &&
+					math.Abs(float64(mk.AimData[1])/float64(mk.AimData[3])) < 1.1 &&
+					mk.Clock.Now().UnixMilli()-mk.LastTriggerTime > config.GetTriggerDelay()+int64(rand.Intn(20)) {
+					logger.Logger.Infof("trigger")
+					//mk.Ctrl.Click(1)
+					mk.Ctrl.MouseBtnDown(input.MouseBtnLeft)
+					mk.sleep(time.Duration(10+rand.Int31()%10) * time.Millisecond)
+					mk.Ctrl.MouseBtnUp(input.MouseBtnLeft)
+					mk.LastTriggerTime = mk.Clock.Now().UnixMilli()
+				} else if !mk.wait(ctx, 7*time.Millisecond) {
+					return
+				}
+				if ctx.Err() != nil {
 					return
-				default:
-					if math.Abs(float64(mk.AimData[0])/float64(mk.AimData[2])) < 1.1 &&
-						math.Abs(float64(mk.AimData[1])/float64(mk.AimData[3])) < 1.1 &&
-						time.Now().UnixMilli()-mk.LastTriggerTime > config.GetTriggerDelay()+int64(rand.Intn(20)) {
-						logger.Logger.Infof("trigger")
-						//mk.Ctrl.Click(1)
-						mk.Ctrl.MouseBtnDown(input.MouseBtnLeft)
-						time.Sleep(time.Duration(10+rand.Int31()%10) * time.Millisecond)
-						mk.Ctrl.MouseBtnUp(input.MouseBtnLeft)
-						mk.LastTriggerTime = time.Now().UnixMilli()
-					} else {
-						time.Sleep(7 * time.Millisecond)
-					}
 				}
 			}
 		},
 	}
-	Macros["trigger_left"] = Macro{
+	m["trigger_left"] = Macro{
 		Name:        "AI自动扳机",
 		Description: "开火键的扳机,用于蓄力类武器",
-		Fn: func(mk *MacroMouseKeyboard, ch chan bool) {
+		Run: func(ctx context.Context, mk *MacroMouseKeyboard) {
 			mk.Ctrl.MouseBtnDown(input.MouseBtnLeft)
 			for {
 				select {
-				case <-ch:
+				case <-ctx.Done():
 					mk.Ctrl.MouseBtnUp(input.MouseBtnLeft)
 					return
 				default:
 					if math.Abs(float64(mk.AimData[0])/float64(mk.AimData[2])) < 0.5 &&
 						math.Abs(float64(mk.AimData[1])/float64(mk.AimData[3])) < 0.8 &&
-						time.Now().UnixMilli()-mk.LastTriggerTime > config.GetTriggerDelay() {
+						mk.Clock.Now().UnixMilli()-mk.LastTriggerTime > config.GetTriggerDelay() {
 						mk.Ctrl.MouseBtnUp(input.MouseBtnLeft)
-						time.Sleep(20 * time.Millisecond)
+						mk.sleep(20 * time.Millisecond)
 						mk.Ctrl.MouseBtnDown(input.MouseBtnLeft)
 					}
 				}
 			}
 		},
 	}
-	Macros["btn_left"] = Macro{
+	m["btn_left"] = Macro{
 		Name:        "左键",
 		Description: "普通的左键功能，用于其他按键映射",
-		Fn: func(mk *MacroMouseKeyboard, ch chan bool) {
+		Run: func(ctx context.Context, mk *MacroMouseKeyboard) {
 			//now := time.Now()
 			mk.Ctrl.MouseBtnDown(input.MouseBtnLeft)
-			<-ch // 等待信号停止
+			mk.waitRelease(ctx) // 等待信号停止
 			mk.Ctrl.MouseBtnUp(input.MouseBtnLeft)
 			//fmt.Println(time.Now().Sub(now))
 		},
 	}
 
-	Macros["test"] = Macro{
+	m["test"] = Macro{
 		Name:        "左键",
 		Description: "普通的左键功能，用于其他按键映射",
-		Fn: func(mk *MacroMouseKeyboard, ch chan bool) {
+		Run: func(ctx context.Context, mk *MacroMouseKeyboard) {
 			mk.Ctrl.MouseBtnDown(input.MouseBtnLeft)
+			defer mk.Ctrl.MouseBtnUp(input.MouseBtnLeft)
 			for i := 0; i <= 10; i++ {
 				mk.Ctrl.MouseMove(100, 0, 0)
-				mk.LastDecTime = time.Now()
+				mk.LastDecTime = mk.Clock.Now()
 				mk.A = true
-				time.Sleep(time.Millisecond * 500)
+				ok := mk.wait(ctx, time.Millisecond*500)
 				mk.Ctrl.MouseMove(-100, 0, 0)
-
-				time.Sleep(time.Second * 1)
+				if !ok || !mk.wait(ctx, time.Second*1) {
+					return
+				}
 			}
-			<-ch // 等待信号停止
-			mk.Ctrl.MouseBtnUp(input.MouseBtnLeft)
+			mk.waitRelease(ctx) // 等待信号停止
 		},
 	}
 
-	Macros["forward"] = Macro{
+	m["forward"] = Macro{
 		Name:        "前进",
 		Description: "普通的前进功能，用于其他按键映射",
-		Fn: func(mk *MacroMouseKeyboard, ch chan bool) {
+		Run: func(ctx context.Context, mk *MacroMouseKeyboard) {
 			//now := time.Now()
 			mk.Ctrl.MouseBtnDown(input.MouseBtnForward)
-			<-ch // 等待信号停止
+			mk.waitRelease(ctx) // 等待信号停止
 			mk.Ctrl.MouseBtnUp(input.MouseBtnForward)
 			//fmt.Println(time.Now().Sub(now))
 		},
 	}
 
-	Macros["switch"] = Macro{
+	m["switch"] = Macro{
 		Name:        "切换",
 		Description: "切换原生功能与宏功能",
-		Fn: func(mk *MacroMouseKeyboard, ch chan bool) {
+		Run: func(ctx context.Context, mk *MacroMouseKeyboard) {
+			MousedictMutex.Lock()
 			MouseConfigDict, MouseConfigDictSwitch = MouseConfigDictSwitch, MouseConfigDict
+			MousedictMutex.Unlock()
 			mk.Ctrl.MouseBtnDown(input.MouseBtnLeft)
-			<-ch // 等待信号停止
+			mk.waitRelease(ctx) // 等待信号停止
 			mk.Ctrl.MouseBtnUp(input.MouseBtnLeft)
 		},
 	}
-	loadPlugins()
+	return m
+}
 
-	return &MacroMouseKeyboard{
-		MouseBtnArgs: mouseBtnArgs,
-		KeyArgs:      keyArgs,
-		Ctrl:         controler,
-		Macros:       Macros,
+func NewMacroMouseKeyboard(controler MouseCtrl) *MacroMouseKeyboard {
+	mk := NewMacroMouseKeyboardWithClock(controler, clock.Real)
+	if err := LoadRecoils(RecoilFile); err != nil {
+		logger.Logger.Fatalf("%v", err)
 	}
+	LoadMacroDir(config.GetMacrosDir())
+	LoadScripts()
+	return mk
+}
+
+// NewMacroMouseKeyboardWithClock 只注册内置宏，不读取压枪配置、宏目录和脚本，宏的计时使用 c。
+// 用于测试(配合 clock.Fake)或需要自己加载宏的场合
+func NewMacroMouseKeyboardWithClock(controler MouseCtrl, c clock.Clock) *MacroMouseKeyboard {
+	setSource(macroSource{kindBuiltin, "builtin"}, builtinMacros())
+	mk := &MacroMouseKeyboard{
+		Ctrl:          controler,
+		Clock:         c,
+		repeating:     make(map[byte]chan struct{}),
+		pressed:       make(map[string]*pressedState),
+		stale:         make(map[string]*pressedState),
+		bindings:      make(map[bindingKey]string),
+		holding:       make(map[bindingKey]string),
+		forwarded:     make(map[byte]bool),
+		sent:          make(map[bindingKey]bool),
+		leaderSwallow: make(map[comboKey]bool),
+		leaderHeld:    make(map[comboKey]string),
+		invocations:   make(map[uint64]*Invocation),
+		held:          make(map[bindingKey]*Invocation),
+	}
+	activeKB.Store(mk)
+	return mk
 }
 
 func (mk *MacroMouseKeyboard) MouseMove(dx, dy, Wheel int32) error {
@@ -419,52 +572,28 @@ func (mk *MacroMouseKeyboard) MouseMove(dx, dy, Wheel int32) error {
 }
 
 func (mk *MacroMouseKeyboard) MouseBtnDown(keyCode byte, devName string) error {
+	mk.trackButton(devName, keyCode, true)
+	return mk.feed(keyEvent{devName: devName, isMouse: true, code: keyCode, down: true})
+}
 
-	// 1. 优先根据设备名获取该设备的宏配置（外层 map 键为设备名）
-	//fmt.Println(strings.ToLower(devName))
-	deviceMacroConfig, deviceExists := MouseConfigDict[strings.ToLower(devName)]
-	if !deviceExists {
-		// 设备无宏配置，直接调用底层控制器
-		return mk.Ctrl.MouseBtnDown(keyCode)
-	}
-	// 2. 在设备配置中查找当前按键码对应的宏标识符
-	macroID, keyExists := deviceMacroConfig[keyCode]
-	if !keyExists {
-		// 当前按键在该设备下无宏配置，直接调用底层控制器
-		return mk.Ctrl.MouseBtnDown(keyCode)
-	}
-	if macroFunc, exists := mk.Macros[macroID]; exists { // 如果有宏函数，执行宏
-		if _, ok := mk.MouseBtnArgs[devName]; ok {
-			go macroFunc.Fn(mk, mk.MouseBtnArgs[devName][keyCode])
-		} else {
-			go macroFunc.Fn(mk, mk.MouseBtnArgs["default"][keyCode])
-		}
+func (mk *MacroMouseKeyboard) MouseBtnUp(keyCode byte, devName string) error {
+	if mk.trackButton(devName, keyCode, false) {
 		return nil
 	}
-	return mk.Ctrl.MouseBtnDown(keyCode) // 如果没有宏函数，直接调用控制器的MouseBtnDown
+	return mk.feed(keyEvent{devName: devName, isMouse: true, code: keyCode})
 }
 
-func (mk *MacroMouseKeyboard) MouseBtnUp(keyCode byte, devName string) error {
-
-	// 1. 优先根据设备名获取该设备的宏配置（外层 map 键为设备名）
-	deviceMacroConfig, deviceExists := MouseConfigDict[strings.ToLower(devName)]
-	if !deviceExists {
-		// 设备无宏配置，直接调用底层控制器
-		return mk.Ctrl.MouseBtnUp(keyCode)
-	}
-	// 2. 在设备配置中查找当前按键码对应的宏标识符
-	macroID, keyExists := deviceMacroConfig[keyCode]
-	if !keyExists {
-		// 当前按键在该设备下无宏配置，直接调用底层控制器
-		return mk.Ctrl.MouseBtnUp(keyCode)
+// forwardBtnDown 将鼠标按键转发给控制器，目标端不支持的按键直接丢弃
+func (mk *MacroMouseKeyboard) forwardBtnDown(keyCode byte) error {
+	if !input.MouseBtnInMask(mk.Ctrl.MouseBtnMask(), keyCode) {
+		logger.Logger.Debugf("丢弃目标端不支持的鼠标按键: 0x%02X", keyCode)
+		return nil
 	}
-	if _, exists := mk.Macros[macroID]; exists { // 如果有宏函数，执行宏
-		if _, ok := mk.MouseBtnArgs[devName]; ok {
-			mk.MouseBtnArgs[devName][keyCode] <- true
-		} else {
-			mk.MouseBtnArgs["default"][keyCode] <- true
-		}
-		//mk.MouseBtnArgs[keyCode] <- true // 发送信号停止宏
+	return mk.Ctrl.MouseBtnDown(keyCode)
+}
+
+func (mk *MacroMouseKeyboard) forwardBtnUp(keyCode byte) error {
+	if !input.MouseBtnInMask(mk.Ctrl.MouseBtnMask(), keyCode) {
 		return nil
 	}
 	return mk.Ctrl.MouseBtnUp(keyCode)
@@ -472,60 +601,23 @@ func (mk *MacroMouseKeyboard) MouseBtnUp(keyCode byte, devName string) error {
 
 // 下面两个是如果没有宏配置就啥也不干的版本
 func (mk *MacroMouseKeyboard) BtnDown(keyCode byte, devName string) error {
-
-	// 1. 优先根据设备名获取该设备的宏配置（外层 map 键为设备名）
-	//fmt.Println(strings.ToLower(devName))
-	deviceMacroConfig, deviceExists := MouseConfigDict[strings.ToLower(devName)]
-	if !deviceExists {
-		// 设备无宏配置，直接调用底层控制器
-		return nil
-	}
-	// 2. 在设备配置中查找当前按键码对应的宏标识符
-	macroID, keyExists := deviceMacroConfig[keyCode]
-	if !keyExists {
-		// 当前按键在该设备下无宏配置，直接调用底层控制器
-		return nil
-	}
-	if macroFunc, exists := mk.Macros[macroID]; exists { // 如果有宏函数，执行宏
-		if _, ok := mk.MouseBtnArgs[devName]; ok {
-			go macroFunc.Fn(mk, mk.MouseBtnArgs[devName][keyCode])
-		} else {
-			go macroFunc.Fn(mk, mk.MouseBtnArgs["default"][keyCode])
-		}
-		return nil
-	}
+	mk.pressBinding(devName, true, keyCode)
 	return nil
 }
 
 func (mk *MacroMouseKeyboard) BtnUp(keyCode byte, devName string) error {
-
-	// 1. 优先根据设备名获取该设备的宏配置（外层 map 键为设备名）
-	deviceMacroConfig, deviceExists := MouseConfigDict[strings.ToLower(devName)]
-	if !deviceExists {
-		// 设备无宏配置，直接调用底层控制器
-		return nil
-	}
-	// 2. 在设备配置中查找当前按键码对应的宏标识符
-	macroID, keyExists := deviceMacroConfig[keyCode]
-	if !keyExists {
-		// 当前按键在该设备下无宏配置，直接调用底层控制器
-		return nil
-	}
-	if _, exists := mk.Macros[macroID]; exists { // 如果有宏函数，执行宏
-		if _, ok := mk.MouseBtnArgs[devName]; ok {
-			mk.MouseBtnArgs[devName][keyCode] <- true
-		} else {
-			mk.MouseBtnArgs["default"][keyCode] <- true
-		}
-		//mk.MouseBtnArgs[keyCode] <- true // 发送信号停止宏
-		return nil
-	}
+	mk.releaseBinding(devName, true, keyCode)
 	return nil
 }
-func (mk *MacroMouseKeyboard) KeyDown(keyCode uint16) error {
-	return mk.Ctrl.KeyDown(input.Linux2hid[keyCode])
+
+func (mk *MacroMouseKeyboard) KeyDown(keyCode uint16, devName string) error {
+	mk.trackKey(devName, keyCode, true)
+	return mk.feed(keyEvent{devName: devName, code: input.Linux2hid[keyCode], evCode: keyCode, down: true})
 }
 
-func (mk *MacroMouseKeyboard) KeyUp(keyCode uint16) error {
-	return mk.Ctrl.KeyUp(input.Linux2hid[keyCode])
+func (mk *MacroMouseKeyboard) KeyUp(keyCode uint16, devName string) error {
+	if mk.trackKey(devName, keyCode, false) {
+		return nil
+	}
+	return mk.feed(keyEvent{devName: devName, code: input.Linux2hid[keyCode], evCode: keyCode})
 }
@@ -0,0 +1,96 @@
+package macros
+
+import (
+	"context"
+	"input2com/internal/clock"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// timedMacro 像按下绑定的按键一样在 Fake 时钟上启动宏，返回控制器、时钟、松开函数和宏结束时关闭的通道
+func timedMacro(t *testing.T, binding string) (*fakeCtrl, *clock.Fake, func(), <-chan struct{}) {
+	start := time.Unix(0, 0)
+	fc := clock.NewFake(start)
+	ctrl := &fakeCtrl{clock: fc, start: start}
+	mk := NewMacroMouseKeyboardWithClock(ctrl, fc)
+	name, macro, args, err := resolveMacro(currentMacros(), binding)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inv, ctx := mk.newInvocation(context.Background(), bindingKey{source: "mouse0", isMouse: true, code: 0x04}, name, args)
+	t.Cleanup(inv.stop)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		mk.runInvocation(ctx, inv, macro)
+	}()
+	fc.BlockUntil(1)
+	return ctrl, fc, inv.stop, done
+}
+
+func expectCalls(t *testing.T, ctrl *fakeCtrl, want ...string) {
+	t.Helper()
+	if got := ctrl.Calls(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func waitDone(t *testing.T, done <-chan struct{}) {
+	t.Helper()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("macro did not stop after release")
+	}
+}
+
+func TestAutofireTiming(t *testing.T) {
+	ctrl, fc, release, done := timedMacro(t, "btn_left_hold_autofire")
+	expectCalls(t, ctrl, "0ms mdown 0x1")
+	fc.Advance(8 * time.Millisecond)
+	expectCalls(t, ctrl, "8ms mup 0x1")
+	fc.Advance(24 * time.Millisecond)
+	expectCalls(t, ctrl, "16ms mdown 0x1", "24ms mup 0x1", "32ms mdown 0x1")
+
+	release() // 按下的这次单击仍会完整松开
+	fc.Advance(8 * time.Millisecond)
+	waitDone(t, done)
+	expectCalls(t, ctrl, "40ms mup 0x1")
+}
+
+func TestClickRepeatTiming(t *testing.T) {
+	ctrl, fc, release, done := timedMacro(t, "click_repeat(interval=50ms)")
+	expectCalls(t, ctrl, "0ms mdown 0x1")
+	fc.Advance(200 * time.Millisecond)
+	expectCalls(t, ctrl,
+		"10ms mup 0x1", "50ms mdown 0x1", "60ms mup 0x1", "100ms mdown 0x1",
+		"110ms mup 0x1", "150ms mdown 0x1", "160ms mup 0x1", "200ms mdown 0x1")
+
+	release()
+	fc.Advance(10 * time.Millisecond)
+	waitDone(t, done)
+	expectCalls(t, ctrl, "210ms mup 0x1")
+}
+
+func TestDeclarativeTiming(t *testing.T) {
+	registerTestMacros(t, `
+- name: test_timing_burst
+  steps:
+    - repeat: {count: 2, steps: [{tap: {key: left, hold: 15ms}}, {wait: 35ms}]}
+    - move: {x: 5}
+  on_release:
+    - tap: a
+`)
+	ctrl, fc, release, done := timedMacro(t, "test_timing_burst")
+	expectCalls(t, ctrl, "0ms mdown 0x1")
+	fc.Advance(150 * time.Millisecond) // steps 在 100ms 执行完，之后等待松开
+	expectCalls(t, ctrl, "15ms mup 0x1", "50ms mdown 0x1", "65ms mup 0x1", "100ms move 5 0 0")
+
+	release()
+	fc.BlockUntil(1) // on_release 中的 tap
+	expectCalls(t, ctrl, "150ms kdown 0x4")
+	fc.Advance(10 * time.Millisecond)
+	waitDone(t, done)
+	expectCalls(t, ctrl, "160ms kup 0x4")
+}
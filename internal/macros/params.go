@@ -0,0 +1,338 @@
+package macros
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"input2com/internal/input"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 宏参数类型
+const (
+	ParamInt      = "int"
+	ParamFloat    = "float"
+	ParamBool     = "bool"
+	ParamString   = "string"
+	ParamDuration = "duration" // 50ms、1.5s，纯数字按毫秒
+	ParamKey      = "key"      // 按键或鼠标键名，同配置文件
+)
+
+// Param 宏参数的定义，没有默认值的参数必须在绑定中给出
+type Param struct {
+	Name        string   `json:"name" yaml:"name"`
+	Type        string   `json:"type" yaml:"type"`
+	Default     string   `json:"default,omitempty" yaml:"default,omitempty"`
+	Description string   `json:"description,omitempty" yaml:"description,omitempty"`
+	Options     []string `json:"options,omitempty" yaml:"options,omitempty"` // 可选值，为空时不限制
+}
+
+// KeyArg key 类型参数的值
+type KeyArg struct {
+	Name    string
+	Code    byte
+	IsMouse bool
+}
+
+// Args 参数名 -> 解析后的值(int、float64、bool、string、time.Duration 或 KeyArg)
+type Args map[string]any
+
+// Parse 将字符串解析为参数类型的值
+func (p Param) Parse(s string) (any, error) {
+	if len(p.Options) > 0 {
+		valid := false
+		for _, o := range p.Options {
+			valid = valid || o == s
+		}
+		if !valid {
+			return nil, fmt.Errorf("%s: %q is not one of %v", p.Name, s, p.Options)
+		}
+	}
+	var value any
+	var err error
+	switch p.Type {
+	case ParamInt:
+		value, err = strconv.Atoi(s)
+	case ParamFloat:
+		value, err = strconv.ParseFloat(s, 64)
+	case ParamBool:
+		value, err = strconv.ParseBool(s)
+	case ParamString, "":
+		value = s
+	case ParamDuration:
+		value, err = parseDurationArg(s)
+	case ParamKey:
+		code, isMouse, ok := input.ParseKey(s)
+		if !ok {
+			err = fmt.Errorf("unknown key or button")
+		}
+		value = KeyArg{Name: s, Code: code, IsMouse: isMouse}
+	default:
+		return nil, fmt.Errorf("%s: unknown parameter type %q", p.Name, p.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: invalid %s %q", p.Name, p.Type, s)
+	}
+	return value, nil
+}
+
+func parseDurationArg(s string) (time.Duration, error) {
+	if d, err := time.ParseDuration(s); err == nil && d >= 0 {
+		return d, nil
+	}
+	ms, err := strconv.ParseUint(s, 10, 32)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(ms) * time.Millisecond, nil
+}
+
+// ValidateParams 检查参数定义，默认值需能按类型解析
+func ValidateParams(params []Param) error {
+	seen := make(map[string]bool)
+	for _, p := range params {
+		if p.Name == "" {
+			return fmt.Errorf("parameter name is required")
+		}
+		if seen[p.Name] {
+			return fmt.Errorf("duplicate parameter %q", p.Name)
+		}
+		seen[p.Name] = true
+		if p.Default != "" {
+			if _, err := p.Parse(p.Default); err != nil {
+				return fmt.Errorf("default of %v", err)
+			}
+		} else if _, err := (Param{Name: p.Name, Type: p.Type}).Parse(zeroArg(p.Type)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func zeroArg(typ string) string {
+	switch typ {
+	case ParamBool:
+		return "false"
+	case ParamDuration, ParamInt, ParamFloat:
+		return "0"
+	case ParamKey:
+		return "left"
+	}
+	return ""
+}
+
+// BindArgs 按宏的参数定义解析参数，raw 可以用参数名或位置("0"、"1"...)作为键，未给出的参数使用默认值
+func (m Macro) BindArgs(raw map[string]string) (Args, error) {
+	args := make(Args, len(m.Params))
+	for key, value := range raw {
+		name := key
+		if i, err := strconv.Atoi(key); err == nil {
+			if i >= len(m.Params) {
+				return nil, fmt.Errorf("%s takes %d parameters", m.Name, len(m.Params))
+			}
+			name = m.Params[i].Name
+		}
+		if _, dup := raw[name]; dup && name != key {
+			return nil, fmt.Errorf("parameter %s given twice", name)
+		}
+		p, ok := m.param(name)
+		if !ok {
+			return nil, fmt.Errorf("%s has no parameter %q", m.Name, name)
+		}
+		v, err := p.Parse(value)
+		if err != nil {
+			return nil, err
+		}
+		args[name] = v
+	}
+	for _, p := range m.Params {
+		if _, ok := args[p.Name]; ok {
+			continue
+		}
+		if p.Default == "" && p.Type != ParamString {
+			return nil, fmt.Errorf("%s: parameter %s is required", m.Name, p.Name)
+		}
+		v, err := p.Parse(p.Default)
+		if err != nil {
+			return nil, err
+		}
+		args[p.Name] = v
+	}
+	return args, nil
+}
+
+func (m Macro) param(name string) (Param, bool) {
+	for _, p := range m.Params {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return Param{}, false
+}
+
+func (a Args) Int(name string) int {
+	v, _ := a[name].(int)
+	return v
+}
+
+func (a Args) Float(name string) float64 {
+	v, _ := a[name].(float64)
+	return v
+}
+
+func (a Args) Bool(name string) bool {
+	v, _ := a[name].(bool)
+	return v
+}
+
+func (a Args) String(name string) string {
+	v, _ := a[name].(string)
+	return v
+}
+
+func (a Args) Duration(name string) time.Duration {
+	v, _ := a[name].(time.Duration)
+	return v
+}
+
+func (a Args) Key(name string) KeyArg {
+	v, _ := a[name].(KeyArg)
+	return v
+}
+
+// Text 以绑定中的写法输出参数值
+func (a Args) Text(name string) string {
+	switch v := a[name].(type) {
+	case nil:
+		return ""
+	case KeyArg:
+		return v.Name
+	case time.Duration:
+		return v.String()
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// MarshalJSON 参数值按绑定中的写法输出
+func (a Args) MarshalJSON() ([]byte, error) {
+	result := make(map[string]string, len(a))
+	for name := range a {
+		result[name] = a.Text(name)
+	}
+	return json.Marshal(result)
+}
+
+type argsKey struct{}
+
+// ArgsFromContext 返回 Run 的 ctx 中的参数，没有参数时返回空的 Args
+func ArgsFromContext(ctx context.Context) Args {
+	if args, ok := ctx.Value(argsKey{}).(Args); ok {
+		return args
+	}
+	return Args{}
+}
+
+// 宏调用写法: name(1, key=value, text="a, b")
+var macroCallRe = regexp.MustCompile(`^([^()\s]+)\s*\((.*)\)$`)
+
+// ParseMacroCall 解析带参数的宏调用，位置参数以 "0"、"1"... 作为键
+func ParseMacroCall(binding string) (name string, raw map[string]string, ok bool) {
+	m := macroCallRe.FindStringSubmatch(strings.TrimSpace(binding))
+	if m == nil {
+		return "", nil, false
+	}
+	if _, _, isLayer := ParseLayerAction(binding); isLayer {
+		return "", nil, false
+	}
+	if _, isProfile := ParseProfileAction(binding); isProfile || m[1] == "th" {
+		return "", nil, false
+	}
+	parts, err := splitArgs(m[2])
+	if err != nil {
+		return "", nil, false
+	}
+	raw = make(map[string]string, len(parts))
+	for i, part := range parts {
+		key, value := strconv.Itoa(i), part
+		if k, v, found := strings.Cut(part, "="); found && isIdent(strings.TrimSpace(k)) {
+			key, value = strings.TrimSpace(k), strings.TrimSpace(v)
+		}
+		raw[key] = unquote(value)
+	}
+	return m[1], raw, true
+}
+
+var identRe = regexp.MustCompile(`^[A-Za-z_]\w*$`)
+
+func isIdent(s string) bool {
+	return identRe.MatchString(s)
+}
+
+func unquote(s string) string {
+	if len(s) >= 2 && (s[0] == '"' || s[0] == '\'') && s[len(s)-1] == s[0] {
+		if u, err := strconv.Unquote(`"` + s[1:len(s)-1] + `"`); err == nil {
+			return u
+		}
+		return s[1 : len(s)-1]
+	}
+	return s
+}
+
+// splitArgs 按顶层的逗号分割参数，引号和括号内的逗号不分割
+func splitArgs(s string) ([]string, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	var parts []string
+	depth, start := 0, 0
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+			if depth < 0 {
+				return nil, fmt.Errorf("unbalanced parentheses")
+			}
+		case c == ',' && depth == 0:
+			parts = append(parts, strings.TrimSpace(s[start:i]))
+			start = i + 1
+		}
+	}
+	if quote != 0 || depth != 0 {
+		return nil, fmt.Errorf("unbalanced quotes or parentheses")
+	}
+	return append(parts, strings.TrimSpace(s[start:])), nil
+}
+
+// resolveMacro 在 macros 中将绑定解析为宏和参数，绑定可以是宏名或带参数的调用
+func resolveMacro(macros map[string]Macro, binding string) (string, Macro, Args, error) {
+	if macro, ok := macros[binding]; ok {
+		args, err := macro.BindArgs(nil)
+		return binding, macro, args, err
+	}
+	name, raw, ok := ParseMacroCall(binding)
+	if !ok {
+		return "", Macro{}, nil, fmt.Errorf("unknown macro %q", binding)
+	}
+	macro, ok := macros[name]
+	if !ok {
+		return "", Macro{}, nil, fmt.Errorf("unknown macro %q", name)
+	}
+	args, err := macro.BindArgs(raw)
+	return name, macro, args, err
+}
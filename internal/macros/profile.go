@@ -0,0 +1,235 @@
+package macros
+
+import (
+	"fmt"
+	"input2com/internal/config"
+	"input2com/internal/logger"
+	"input2com/internal/sensitivity"
+	"maps"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// 配置方案(profile): 一组鼠标/键盘绑定、层、灵敏度和可以绑定的宏，顶层配置就是 default 方案。
+// 通过 profile(name) 绑定或接口切换，切换时先按旧方案释放所有按下的按键和运行中的宏，再换上新方案
+
+const (
+	DefaultProfile = "default"
+	NextProfile    = "next" // profile(next) 按顺序切换到下一个方案
+
+	// ProfileStateFile 记录当前方案，重启后恢复
+	ProfileStateFile = "config/active_profile"
+)
+
+var profileActionRe = regexp.MustCompile(`^profile\(([\w-]+)\)$`)
+
+// ParseProfileAction 解析切换方案的绑定，如 "profile(cad)"
+func ParseProfileAction(binding string) (string, bool) {
+	m := profileActionRe.FindStringSubmatch(strings.TrimSpace(binding))
+	if m == nil {
+		return "", false
+	}
+	return m[1], true
+}
+
+// profile 方案的当前内容，通过接口修改的绑定和灵敏度在切换走时保存回来
+type profile struct {
+	name        string
+	mouse       map[string]map[byte]string
+	keyboard    map[byte]string
+	layers      []config.LayerConfig
+	sensitivity config.SensitivityConfig
+	macros      []string
+}
+
+// ProfileStatus 方案列表和当前方案
+type ProfileStatus struct {
+	Active   string   `json:"active"`
+	Profiles []string `json:"profiles"`
+}
+
+var (
+	profiles      []*profile // default 在最前，LoadProfiles 之后不再改变
+	activeProfile atomic.Pointer[profile]
+	profileMutex  sync.Mutex // 切换方案时持有，保存和换上方案内容
+)
+
+// LoadProfiles 以当前的绑定、层和灵敏度作为 default 方案，加载配置中的其他方案，
+// 并恢复上次使用的方案。需在加载层和灵敏度之后、开始处理输入之前调用
+func LoadProfiles(cfgs []config.ProfileConfig) error {
+	profileMutex.Lock()
+	defer profileMutex.Unlock()
+	def := &profile{name: DefaultProfile, sensitivity: sensitivity.Get()}
+	MousedictMutex.RLock()
+	def.mouse = MouseConfigDict
+	MousedictMutex.RUnlock()
+	KeyboarddictMutex.RLock()
+	def.keyboard = KeyboardConfigDict
+	KeyboarddictMutex.RUnlock()
+	LayerMutex.RLock()
+	for _, l := range Layers {
+		def.layers = append(def.layers, l.LayerConfig)
+	}
+	LayerMutex.RUnlock()
+
+	result := []*profile{def}
+	for _, c := range cfgs {
+		if c.Name == "" || c.Name == NextProfile || findProfile(result, c.Name) != nil {
+			return fmt.Errorf("profile name %q is empty, reserved or duplicated", c.Name)
+		}
+		// 绑定表在切换后会被接口和 BindDevice 原地修改，每个方案持有自己的副本
+		p := &profile{name: c.Name, mouse: copyMouseDict(def.mouse), keyboard: maps.Clone(def.keyboard), layers: def.layers, sensitivity: def.sensitivity, macros: c.Macros}
+		if c.MouseConfigDict != nil {
+			p.mouse = make(map[string]map[byte]string, len(c.MouseConfigDict))
+			for dev, m := range c.MouseConfigDict {
+				p.mouse[strings.ToLower(dev)] = maps.Clone(m)
+			}
+		}
+		if c.KeyboardConfigDict != nil {
+			p.keyboard = maps.Clone(c.KeyboardConfigDict)
+		}
+		if c.Layers != nil {
+			if err := checkLayers(c.Layers); err != nil {
+				return fmt.Errorf("profile %s: %w", c.Name, err)
+			}
+			p.layers = c.Layers
+		}
+		if c.Sensitivity != nil {
+			if err := sensitivity.Check(*c.Sensitivity); err != nil {
+				return fmt.Errorf("profile %s: sensitivity %w", c.Name, err)
+			}
+			p.sensitivity = *c.Sensitivity
+		}
+		for _, pattern := range c.Macros {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("profile %s: invalid macro pattern %q", c.Name, pattern)
+			}
+		}
+		result = append(result, p)
+	}
+	profiles = result
+	activeProfile.Store(def)
+
+	data, err := os.ReadFile(ProfileStateFile)
+	if err != nil {
+		return nil // 第一次运行
+	}
+	name := strings.TrimSpace(string(data))
+	if p := findProfile(profiles, name); p == nil {
+		logger.Logger.Warnf("上次使用的方案 %s 已不存在，使用 %s", name, DefaultProfile)
+	} else if p != def {
+		applyProfile(p)
+		logger.Logger.Infof("恢复方案 %s", name)
+	}
+	return nil
+}
+
+// copyMouseDict 复制每个设备的绑定表
+func copyMouseDict(m map[string]map[byte]string) map[string]map[byte]string {
+	result := make(map[string]map[byte]string, len(m))
+	for dev, bindings := range m {
+		result[dev] = maps.Clone(bindings)
+	}
+	return result
+}
+
+func findProfile(list []*profile, name string) *profile {
+	for _, p := range list {
+		if p.name == name {
+			return p
+		}
+	}
+	return nil
+}
+
+// applyProfile 保存当前方案的内容后换上 p，调用时需持有 profileMutex
+func applyProfile(p *profile) {
+	if old := activeProfile.Load(); old != nil {
+		MousedictMutex.RLock()
+		old.mouse = MouseConfigDict
+		MousedictMutex.RUnlock()
+		KeyboarddictMutex.RLock()
+		old.keyboard = KeyboardConfigDict
+		KeyboarddictMutex.RUnlock()
+		old.sensitivity = sensitivity.Get()
+	}
+	MousedictMutex.Lock()
+	MouseConfigDict = p.mouse
+	MousedictMutex.Unlock()
+	KeyboarddictMutex.Lock()
+	KeyboardConfigDict = p.keyboard
+	KeyboarddictMutex.Unlock()
+	LoadLayers(p.layers)            // 已在 LoadProfiles 中检查
+	sensitivity.Load(p.sensitivity) // 同上
+	activeProfile.Store(p)
+}
+
+// SwitchProfile 切换到方案 name，NextProfile 为下一个方案。切换期间不处理新的按键事件：
+// 先按旧方案松开所有按下的按键、停止运行中的宏，再换上新方案，并记录到 ProfileStateFile
+func (mk *MacroMouseKeyboard) SwitchProfile(name string) error {
+	profileMutex.Lock()
+	defer profileMutex.Unlock()
+	current := activeProfile.Load()
+	p := findProfile(profiles, name)
+	if name == NextProfile && len(profiles) > 0 {
+		for i, q := range profiles {
+			if q == current {
+				p = profiles[(i+1)%len(profiles)]
+			}
+		}
+	}
+	if p == nil {
+		return fmt.Errorf("unknown profile %q", name)
+	}
+	if p == current {
+		return nil
+	}
+	mk.inputMutex.Lock()
+	mk.markStale()
+	mk.releaseAllLocked()
+	applyProfile(p)
+	mk.inputMutex.Unlock()
+	logger.Logger.Infof("切换到方案 %s", p.name)
+	if err := os.WriteFile(ProfileStateFile, []byte(p.name+"\n"), 0644); err != nil {
+		logger.Logger.Warnf("无法保存当前方案: %v", err)
+	}
+	return nil
+}
+
+// GetProfiles 返回所有方案和当前方案
+func GetProfiles() ProfileStatus {
+	status := ProfileStatus{Active: DefaultProfile, Profiles: []string{}}
+	if p := activeProfile.Load(); p != nil {
+		status.Active = p.name
+	}
+	for _, p := range profiles {
+		status.Profiles = append(status.Profiles, p.name)
+	}
+	if len(status.Profiles) == 0 {
+		status.Profiles = append(status.Profiles, DefaultProfile)
+	}
+	return status
+}
+
+// profileAllows 判断当前方案是否可以使用宏 name
+func profileAllows(name string) bool {
+	p := activeProfile.Load()
+	if p == nil || len(p.macros) == 0 {
+		return true
+	}
+	for _, pattern := range p.macros {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// isValidProfile 判断 profile(name) 绑定中的方案是否存在
+func isValidProfile(name string) bool {
+	return name == NextProfile || name == DefaultProfile || findProfile(profiles, name) != nil
+}
@@ -0,0 +1,45 @@
+package macros
+
+import (
+	"input2com/internal/config"
+	"testing"
+)
+
+func TestProfilesDoNotShareBindings(t *testing.T) {
+	oldMouse, oldKeyboard := MouseConfigDict, KeyboardConfigDict
+	defer func() {
+		MouseConfigDict, KeyboardConfigDict = oldMouse, oldKeyboard
+		profiles = nil
+		activeProfile.Store(nil)
+	}()
+	MouseConfigDict = map[string]map[byte]string{"mouse0": {0x01: "btn_left"}}
+	KeyboardConfigDict = map[byte]string{0x04: "a"}
+	if err := LoadProfiles([]config.ProfileConfig{{Name: "p1"}, {Name: "p2"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	profileMutex.Lock()
+	defer profileMutex.Unlock()
+	applyProfile(findProfile(profiles, "p1"))
+	// 通过接口和 BindDevice 修改 p1 的绑定
+	MouseConfigDict["mouse0"][0x02] = "click_repeat"
+	MouseConfigDict["mouse1"] = map[byte]string{}
+	KeyboardConfigDict[0x05] = "b"
+
+	for _, name := range []string{"p2", DefaultProfile} {
+		applyProfile(findProfile(profiles, name))
+		if _, ok := MouseConfigDict["mouse0"][0x02]; ok {
+			t.Errorf("%s: mouse binding leaked from p1", name)
+		}
+		if _, ok := MouseConfigDict["mouse1"]; ok {
+			t.Errorf("%s: device entry leaked from p1", name)
+		}
+		if _, ok := KeyboardConfigDict[0x05]; ok {
+			t.Errorf("%s: keyboard binding leaked from p1", name)
+		}
+	}
+	applyProfile(findProfile(profiles, "p1"))
+	if MouseConfigDict["mouse0"][0x02] != "click_repeat" || KeyboardConfigDict[0x05] != "b" {
+		t.Error("p1 lost its own changes")
+	}
+}
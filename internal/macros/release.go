@@ -0,0 +1,197 @@
+package macros
+
+import (
+	"input2com/internal/input"
+	"input2com/internal/logger"
+	"strings"
+)
+
+// pressedState 某个输入源当前按下的按键，用于设备移除或退出时释放
+type pressedState struct {
+	buttons map[byte]bool
+	keys    map[uint16]bool
+}
+
+// NeutralReporter 可选接口，控制器实现后在退出前发送全部释放的报告
+type NeutralReporter interface {
+	SendNeutral() error
+}
+
+func (mk *MacroMouseKeyboard) sourceState(devName string) *pressedState {
+	name := strings.ToLower(devName)
+	state, ok := mk.pressed[name]
+	if !ok {
+		state = &pressedState{buttons: make(map[byte]bool), keys: make(map[uint16]bool)}
+		mk.pressed[name] = state
+	}
+	return state
+}
+
+// trackButton 记录按键状态，返回 true 表示这个松开事件对应的按下已经被释放过，应丢弃
+func (mk *MacroMouseKeyboard) trackButton(devName string, keyCode byte, down bool) bool {
+	mk.pressedMutex.Lock()
+	defer mk.pressedMutex.Unlock()
+	state := mk.sourceState(devName)
+	stale := mk.stale[strings.ToLower(devName)]
+	if down {
+		state.buttons[keyCode] = true
+	} else {
+		delete(state.buttons, keyCode)
+	}
+	if stale != nil && stale.buttons[keyCode] {
+		delete(stale.buttons, keyCode)
+		return !down
+	}
+	return false
+}
+
+func (mk *MacroMouseKeyboard) trackKey(devName string, keyCode uint16, down bool) bool {
+	mk.pressedMutex.Lock()
+	defer mk.pressedMutex.Unlock()
+	state := mk.sourceState(devName)
+	stale := mk.stale[strings.ToLower(devName)]
+	if down {
+		state.keys[keyCode] = true
+	} else {
+		delete(state.keys, keyCode)
+	}
+	if stale != nil && stale.keys[keyCode] {
+		delete(stale.keys, keyCode)
+		return !down
+	}
+	return false
+}
+
+// markStale 当前按下的按键将被提前释放但仍按着，之后的松开事件丢弃，用于切换方案
+func (mk *MacroMouseKeyboard) markStale() {
+	mk.pressedMutex.Lock()
+	defer mk.pressedMutex.Unlock()
+	for name, state := range mk.pressed {
+		stale, ok := mk.stale[name]
+		if !ok {
+			stale = &pressedState{buttons: make(map[byte]bool), keys: make(map[uint16]bool)}
+			mk.stale[name] = stale
+		}
+		for btn := range state.buttons {
+			stale.buttons[btn] = true
+		}
+		for key := range state.keys {
+			stale.keys[key] = true
+		}
+	}
+}
+
+// isKeyHeld 判断键盘按键(HID)当前是否在任一输入源上按下
+func (mk *MacroMouseKeyboard) isKeyHeld(hid byte) bool {
+	mk.pressedMutex.Lock()
+	defer mk.pressedMutex.Unlock()
+	for _, state := range mk.pressed {
+		for key := range state.keys {
+			if input.Linux2hid[key] == hid {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// PressKey 由宏以外的来源(如插件)直接按下或松开目标端的按键，不经过绑定。按下的按键按 source 记录，
+// ReleaseDevice(source)、ReleaseAll 和切换方案时松开
+func (mk *MacroMouseKeyboard) PressKey(source string, code byte, isMouse, down bool) error {
+	mk.inputMutex.Lock()
+	defer mk.inputMutex.Unlock()
+	key := bindingKey{strings.ToLower(source), isMouse, code}
+	if down {
+		mk.sent[key] = true
+	} else {
+		delete(mk.sent, key)
+	}
+	return mk.sendKey(code, isMouse, down)
+}
+
+// sendKey 将按键发给目标端，鼠标按键受目标端按键掩码限制
+func (mk *MacroMouseKeyboard) sendKey(code byte, isMouse, down bool) error {
+	switch {
+	case isMouse && down:
+		return mk.forwardBtnDown(code)
+	case isMouse:
+		return mk.forwardBtnUp(code)
+	case down:
+		return mk.Ctrl.KeyDown(code)
+	default:
+		return mk.Ctrl.KeyUp(code)
+	}
+}
+
+// releaseSentLocked 松开 PressKey 按下的按键，source 为空时松开全部，调用时需持有 inputMutex
+func (mk *MacroMouseKeyboard) releaseSentLocked(source string) {
+	for key := range mk.sent {
+		if source != "" && key.source != strings.ToLower(source) {
+			continue
+		}
+		logger.Logger.Infof("释放 %s 按下的按键 0x%02X", key.source, key.code)
+		mk.sendKey(key.code, key.isMouse, false)
+		delete(mk.sent, key)
+	}
+}
+
+// ReleaseDevice 释放某个输入源按下的全部按键，绑定了宏的按键会收到释放信号停止宏
+func (mk *MacroMouseKeyboard) ReleaseDevice(devName string) {
+	mk.inputMutex.Lock()
+	defer mk.inputMutex.Unlock()
+	mk.releaseDeviceLocked(devName)
+}
+
+// releaseDeviceLocked 调用时需持有 inputMutex，松开事件直接进入处理流程
+func (mk *MacroMouseKeyboard) releaseDeviceLocked(devName string) {
+	mk.releaseSentLocked(devName)
+	mk.pressedMutex.Lock()
+	state, ok := mk.pressed[strings.ToLower(devName)]
+	delete(mk.pressed, strings.ToLower(devName))
+	mk.pressedMutex.Unlock()
+	if !ok {
+		return
+	}
+	for btn := range state.buttons {
+		logger.Logger.Infof("释放 %s 的鼠标按键 0x%02X", devName, btn)
+		mk.processLeader(keyEvent{devName: devName, isMouse: true, code: btn})
+	}
+	for key := range state.keys {
+		logger.Logger.Infof("释放 %s 的键盘按键 %d", devName, key)
+		mk.processLeader(keyEvent{devName: devName, code: input.Linux2hid[key], evCode: key})
+	}
+}
+
+// ReleaseAll 释放所有输入源按下的按键，并在控制器支持时发送全部释放的报告，
+// 用于退出，切换输出目标时由 SwitchTarget 调用
+func (mk *MacroMouseKeyboard) ReleaseAll() {
+	mk.inputMutex.Lock()
+	defer mk.inputMutex.Unlock()
+	mk.releaseAllLocked()
+}
+
+// releaseAllLocked 调用时需持有 inputMutex，期间不会有新的按键事件
+func (mk *MacroMouseKeyboard) releaseAllLocked() {
+	mk.pressedMutex.Lock()
+	sources := make([]string, 0, len(mk.pressed))
+	for name := range mk.pressed {
+		sources = append(sources, name)
+	}
+	mk.pressedMutex.Unlock()
+	for _, name := range sources {
+		mk.releaseDeviceLocked(name)
+	}
+	mk.releaseSentLocked("")
+	mk.CancelAll() // 通过接口启动或按键已松开但仍在运行的宏
+	mk.repeatMutex.Lock()
+	for hid, stop := range mk.repeating {
+		close(stop)
+		delete(mk.repeating, hid)
+	}
+	mk.repeatMutex.Unlock()
+	if neutral, ok := unwrapCtrl[NeutralReporter](mk.Ctrl); ok {
+		if err := neutral.SendNeutral(); err != nil {
+			logger.Logger.Errorf("发送释放报告失败: %v", err)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package macros
+
+import (
+	"fmt"
+	"input2com/internal/config"
+	"input2com/internal/logger"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// 宏来自多个来源(代码、压枪配置、宏目录和脚本目录中的每个文件、每个插件)，
+// 重新加载某个来源时只替换它提供的宏，然后整体生成新的 Macros。
+// Macros 发布后不再修改，读取方拿到的总是完整的一份
+
+const (
+	kindRecoil = iota
+	kindBuiltin
+	kindFile
+	kindScript
+	kindPlugin
+)
+
+// macroSource 宏的来源，同名宏以 kind 大的为准
+type macroSource struct {
+	kind int
+	name string // 文件路径或插件名
+}
+
+func (s macroSource) String() string {
+	if s.kind == kindPlugin {
+		return "plugin:" + s.name
+	}
+	return s.name
+}
+
+// LoadStatus 宏的加载情况
+type LoadStatus struct {
+	Sources map[string][]string `json:"sources"` // 来源 -> 宏名
+	Errors  map[string]string   `json:"errors"`  // 文件 -> 错误，出错的文件保留上一次成功加载的宏
+	Updated time.Time           `json:"updated"`
+}
+
+var (
+	macroSources = make(map[macroSource]map[string]Macro) // 由 KeyboarddictMutex 保护
+	loadErrors   = make(map[macroSource]string)
+	updated      time.Time
+
+	// activeKB 当前的 MacroMouseKeyboard，用于停止被移除的宏
+	activeKB atomic.Pointer[MacroMouseKeyboard]
+)
+
+// setSource 替换某个来源的宏，m 为空时移除该来源
+func setSource(src macroSource, m map[string]Macro) {
+	KeyboarddictMutex.Lock()
+	removed := setSourceLocked(src, m)
+	KeyboarddictMutex.Unlock()
+	stopRemoved(removed)
+}
+
+// setSourceLocked 调用时需持有 KeyboarddictMutex，返回不再存在的宏名
+func setSourceLocked(src macroSource, m map[string]Macro) []string {
+	if len(m) == 0 {
+		delete(macroSources, src)
+	} else {
+		macroSources[src] = m
+	}
+	sources := make([]macroSource, 0, len(macroSources))
+	for s := range macroSources {
+		sources = append(sources, s)
+	}
+	sort.Slice(sources, func(i, j int) bool {
+		if sources[i].kind != sources[j].kind {
+			return sources[i].kind < sources[j].kind
+		}
+		return sources[i].name < sources[j].name
+	})
+	result := make(map[string]Macro)
+	owner := make(map[string]macroSource)
+	for _, s := range sources {
+		for name, macro := range macroSources[s] {
+			if prev, ok := owner[name]; ok && (s == src || prev == src) {
+				logger.Logger.Warnf("宏 %s 在 %s 和 %s 中重复定义，使用 %s", name, prev, s, s)
+			}
+			result[name] = macro
+			owner[name] = s
+		}
+	}
+	var removed []string
+	for name := range Macros {
+		if _, ok := result[name]; !ok {
+			removed = append(removed, name)
+		}
+	}
+	Macros = result
+	updated = time.Now()
+	return removed
+}
+
+// stopRemoved 停止已被移除的宏的调用
+func stopRemoved(names []string) {
+	if len(names) == 0 {
+		return
+	}
+	sort.Strings(names)
+	logger.Logger.Infof("移除宏: %v", names)
+	if mk := activeKB.Load(); mk != nil {
+		for _, name := range names {
+			mk.CancelMacroNamed(name)
+		}
+	}
+}
+
+// removeMissing 移除 kind 类型中不在 found 里的来源(文件已被删除)
+func removeMissing(kind int, found map[string]bool) {
+	KeyboarddictMutex.Lock()
+	var removed []string
+	for src := range macroSources {
+		if src.kind == kind && !found[src.name] {
+			removed = append(removed, setSourceLocked(src, nil)...)
+		}
+	}
+	for src := range loadErrors {
+		if src.kind == kind && !found[src.name] {
+			delete(loadErrors, src)
+		}
+	}
+	KeyboarddictMutex.Unlock()
+	stopRemoved(removed)
+}
+
+// setLoadError 记录或清除某个来源的加载错误，返回 err
+func setLoadError(src macroSource, err error) error {
+	KeyboarddictMutex.Lock()
+	defer KeyboarddictMutex.Unlock()
+	if err == nil {
+		delete(loadErrors, src)
+	} else {
+		loadErrors[src] = err.Error()
+	}
+	return err
+}
+
+// LookupMacro 按名字查找宏
+func LookupMacro(name string) (Macro, bool) {
+	KeyboarddictMutex.RLock()
+	defer KeyboarddictMutex.RUnlock()
+	macro, ok := Macros[name]
+	return macro, ok
+}
+
+// currentMacros 返回当前的宏列表，返回的 map 不会再被修改
+func currentMacros() map[string]Macro {
+	KeyboarddictMutex.RLock()
+	defer KeyboarddictMutex.RUnlock()
+	return Macros
+}
+
+// GetLoadStatus 返回各个来源提供的宏和加载错误
+func GetLoadStatus() LoadStatus {
+	KeyboarddictMutex.RLock()
+	defer KeyboarddictMutex.RUnlock()
+	status := LoadStatus{Sources: make(map[string][]string), Errors: make(map[string]string), Updated: updated}
+	for src, m := range macroSources {
+		names := make([]string, 0, len(m))
+		for name := range m {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		status.Sources[src.String()] = names
+	}
+	for src, err := range loadErrors {
+		status.Errors[src.String()] = err
+	}
+	return status
+}
+
+// SetPluginMacros 替换插件注册的宏，宏名不能与其他来源的宏重复。m 为空时移除插件的宏
+func SetPluginMacros(plugin string, m map[string]Macro) error {
+	src := macroSource{kindPlugin, plugin}
+	KeyboarddictMutex.Lock()
+	for name := range m {
+		if _, exists := Macros[name]; exists {
+			if _, own := macroSources[src][name]; !own {
+				KeyboarddictMutex.Unlock()
+				return fmt.Errorf("macro %q already exists", name)
+			}
+		}
+	}
+	removed := setSourceLocked(src, m)
+	KeyboarddictMutex.Unlock()
+	stopRemoved(removed)
+	return nil
+}
+
+// ReloadAll 重新加载压枪配置、宏目录和脚本目录
+func ReloadAll() LoadStatus {
+	if err := LoadRecoils(RecoilFile); err != nil {
+		logger.Logger.Errorf("%v", err)
+	}
+	LoadMacroDir(config.GetMacrosDir())
+	LoadScripts()
+	return GetLoadStatus()
+}
@@ -0,0 +1,406 @@
+package macros
+
+import (
+	"context"
+	"fmt"
+	"input2com/internal/clock"
+	"input2com/internal/config"
+	"input2com/internal/input"
+	"input2com/internal/logger"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+)
+
+// 脚本宏使用 Starlark(Python 子集)编写，放在脚本目录下，文件名(去掉 .star)即宏名:
+//
+//	description = "按住连点左键"
+//	def on_press():
+//	    while not released():
+//	        tap("left", 15)
+//	        wait(35)
+//	def on_release():   # 可选
+//	    release("left")
+//
+// 可以用 params 声明参数，在绑定中传入如 myscript(interval=30)，脚本中用 arg("interval") 读取:
+//
+//	params = [{"name": "interval", "type": "duration", "default": "35ms"}]
+//
+// 脚本不能访问文件和网络，单次调用执行的总步数和运行时间受配置限制，超过运行时间时正在进行的等待会被打断
+
+// ScriptStatus 脚本加载结果
+type ScriptStatus struct {
+	Loaded []string          `json:"loaded"`
+	Errors map[string]string `json:"errors"` // 文件 -> 错误，出错的脚本保留上一次成功加载的版本
+}
+
+var (
+	scriptStatus = ScriptStatus{Errors: map[string]string{}}
+	scriptMutex  sync.Mutex
+)
+
+const scriptEnvKey = "input2com.env"
+
+// scriptEnv 一次脚本调用的运行环境，通过 thread local 传给内置函数
+type scriptEnv struct {
+	mk           *MacroMouseKeyboard
+	ctx          context.Context // 松开(on_press 中)或超过运行时间时结束，等待都会被打断
+	expired      chan struct{}   // 超过运行时间时关闭
+	sched        *clock.Scheduler
+	afterRelease bool
+}
+
+type script struct {
+	name      string
+	onPress   starlark.Callable
+	onRelease starlark.Callable
+}
+
+var scriptFileOptions = &syntax.FileOptions{While: true, TopLevelControl: true, GlobalReassign: true}
+
+// LoadScripts 加载脚本目录下的所有 .star 文件，已删除的脚本对应的宏会被移除
+func LoadScripts() ScriptStatus {
+	dir, maxSteps, _ := config.GetScriptLimits()
+	scriptMutex.Lock()
+	defer scriptMutex.Unlock()
+	status := ScriptStatus{Loaded: []string{}, Errors: map[string]string{}}
+	files, err := filepath.Glob(filepath.Join(dir, "*.star"))
+	if err != nil {
+		status.Errors[dir] = err.Error()
+	}
+	found := make(map[string]bool)
+	for _, file := range files {
+		name := strings.TrimSuffix(filepath.Base(file), ".star")
+		src := macroSource{kindScript, file}
+		found[file] = true
+		macro, err := loadScript(file, name, maxSteps)
+		if err != nil {
+			logger.Logger.Errorf("加载脚本失败: %v", setLoadError(src, err))
+			status.Errors[file] = err.Error()
+			continue
+		}
+		setLoadError(src, nil)
+		setSource(src, map[string]Macro{name: macro})
+		status.Loaded = append(status.Loaded, name)
+	}
+	removeMissing(kindScript, found)
+	sort.Strings(status.Loaded)
+	logger.Logger.Infof("已加载脚本宏: %v", status.Loaded)
+	scriptStatus = status
+	return status
+}
+
+// GetScriptStatus 返回最近一次加载脚本的结果
+func GetScriptStatus() ScriptStatus {
+	scriptMutex.Lock()
+	defer scriptMutex.Unlock()
+	return scriptStatus
+}
+
+func loadScript(file, name string, maxSteps uint64) (Macro, error) {
+	src, err := os.ReadFile(file)
+	if err != nil {
+		return Macro{}, err
+	}
+	thread := &starlark.Thread{Name: name, Print: scriptPrint}
+	thread.SetMaxExecutionSteps(maxSteps)
+	globals, err := starlark.ExecFileOptions(scriptFileOptions, thread, file, src, scriptBuiltins)
+	if err != nil {
+		return Macro{}, err
+	}
+	s := &script{name: name}
+	var ok bool
+	if s.onPress, ok = globals["on_press"].(starlark.Callable); !ok {
+		return Macro{}, fmt.Errorf("%s: on_press function is required", file)
+	}
+	if v, exists := globals["on_release"]; exists {
+		if s.onRelease, ok = v.(starlark.Callable); !ok {
+			return Macro{}, fmt.Errorf("%s: on_release must be a function", file)
+		}
+	}
+	description := "脚本宏 " + filepath.Base(file)
+	if v, ok := globals["description"].(starlark.String); ok {
+		description = string(v)
+	}
+	var params []Param
+	if v, exists := globals["params"]; exists {
+		if params, err = scriptParams(v); err != nil {
+			return Macro{}, fmt.Errorf("%s: params: %v", file, err)
+		}
+	}
+	globals.Freeze()
+	return Macro{Name: name, Description: description, Params: params, Run: s.run}, nil
+}
+
+// scriptParams 将脚本中的 params 转换为参数定义，每个参数是一个 dict，字段同宏文件中的 params
+func scriptParams(v starlark.Value) ([]Param, error) {
+	list, ok := v.(starlark.Indexable)
+	if !ok {
+		return nil, fmt.Errorf("must be a list of dicts")
+	}
+	params := make([]Param, 0, list.Len())
+	for i := 0; i < list.Len(); i++ {
+		dict, ok := list.Index(i).(*starlark.Dict)
+		if !ok {
+			return nil, fmt.Errorf("must be a list of dicts")
+		}
+		var p Param
+		for _, item := range dict.Items() {
+			field, _ := starlark.AsString(item[0])
+			switch field {
+			case "name":
+				p.Name = scriptText(item[1])
+			case "type":
+				p.Type = scriptText(item[1])
+			case "default":
+				p.Default = scriptText(item[1])
+			case "description":
+				p.Description = scriptText(item[1])
+			case "options":
+				options, ok := item[1].(starlark.Indexable)
+				if !ok {
+					return nil, fmt.Errorf("options must be a list")
+				}
+				for j := 0; j < options.Len(); j++ {
+					p.Options = append(p.Options, scriptText(options.Index(j)))
+				}
+			default:
+				return nil, fmt.Errorf("unknown field %s", item[0])
+			}
+		}
+		params = append(params, p)
+	}
+	return params, ValidateParams(params)
+}
+
+// scriptText 字符串取原值，其他值取字面写法，如 30、True
+func scriptText(v starlark.Value) string {
+	if s, ok := starlark.AsString(v); ok {
+		return s
+	}
+	return v.String()
+}
+
+func scriptPrint(thread *starlark.Thread, msg string) {
+	logger.Logger.Infof("[%s] %s", thread.Name, msg)
+}
+
+// run 按下时执行 on_press，松开后执行 on_release
+func (s *script) run(ctx context.Context, mk *MacroMouseKeyboard) {
+	s.call(s.onPress, ctx, mk, false)
+	mk.waitRelease(ctx)
+	if s.onRelease != nil {
+		s.call(s.onRelease, context.WithoutCancel(ctx), mk, true)
+	}
+}
+
+// call 执行脚本函数，超过运行时间时取消 ctx 并终止线程，步数限制是这次调用的总步数
+func (s *script) call(fn starlark.Callable, ctx context.Context, mk *MacroMouseKeyboard, afterRelease bool) {
+	_, maxSteps, maxRunTime := config.GetScriptLimits()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	env := &scriptEnv{mk: mk, ctx: ctx, expired: make(chan struct{}), sched: mk.scheduler(), afterRelease: afterRelease}
+	thread := &starlark.Thread{Name: s.name, Print: scriptPrint}
+	thread.SetLocal(scriptEnvKey, env)
+	thread.SetMaxExecutionSteps(maxSteps)
+	timer := time.AfterFunc(maxRunTime, func() {
+		thread.Cancel("run time limit exceeded")
+		close(env.expired)
+		cancel()
+	})
+	defer timer.Stop()
+	if _, err := starlark.Call(thread, fn, nil, nil); err != nil {
+		if evalErr, ok := err.(*starlark.EvalError); ok {
+			err = fmt.Errorf("%s", evalErr.Backtrace())
+		}
+		logger.Logger.Errorf("脚本 %s 执行出错: %v", s.name, err)
+	}
+}
+
+func (env *scriptEnv) isReleased() bool {
+	if env.afterRelease {
+		return true
+	}
+	select {
+	case <-env.ctx.Done():
+		return true
+	default:
+		return false
+	}
+}
+
+// builtin 包装内置函数，脚本加载时(没有运行环境)调用会报错
+func builtin(name string, fn func(env *scriptEnv, thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error)) *starlark.Builtin {
+	return starlark.NewBuiltin(name, func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		env, ok := thread.Local(scriptEnvKey).(*scriptEnv)
+		if !ok {
+			return nil, fmt.Errorf("%s: can only be called from on_press/on_release", name)
+		}
+		return fn(env, thread, b, args, kwargs)
+	})
+}
+
+func parseScriptKey(b *starlark.Builtin, name string) (byte, bool, error) {
+	code, isMouse, ok := input.ParseKey(name)
+	if !ok {
+		return 0, false, fmt.Errorf("%s: unknown key or button %q", b.Name(), name)
+	}
+	return code, isMouse, nil
+}
+
+// scriptWait 等待 ms 毫秒，松开或超过运行时间时提前返回 False
+func scriptWait(env *scriptEnv, ms int) bool {
+	return env.sched.Wait(time.Duration(ms)*time.Millisecond, env.ctx.Done())
+}
+
+// callCtx 被调用的宏使用的 ctx，on_release 中被调用的宏立即收到松开信号
+func (env *scriptEnv) callCtx() context.Context {
+	if env.afterRelease {
+		return context.WithoutCancel(env.ctx)
+	}
+	return env.ctx
+}
+
+func pressKey(env *scriptEnv, b *starlark.Builtin, name string, down bool) error {
+	code, isMouse, err := parseScriptKey(b, name)
+	if err != nil {
+		return err
+	}
+	stepPress(env.mk, Step{Code: code, IsMouse: isMouse}, down)
+	return nil
+}
+
+var scriptBuiltins = starlark.StringDict{
+	"press": builtin("press", func(env *scriptEnv, thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var key string
+		if err := starlark.UnpackArgs(b.Name(), args, kwargs, "key", &key); err != nil {
+			return nil, err
+		}
+		return starlark.None, pressKey(env, b, key, true)
+	}),
+	"release": builtin("release", func(env *scriptEnv, thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var key string
+		if err := starlark.UnpackArgs(b.Name(), args, kwargs, "key", &key); err != nil {
+			return nil, err
+		}
+		return starlark.None, pressKey(env, b, key, false)
+	}),
+	"tap": builtin("tap", func(env *scriptEnv, thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var key string
+		hold := 10
+		if err := starlark.UnpackArgs(b.Name(), args, kwargs, "key", &key, "hold?", &hold); err != nil {
+			return nil, err
+		}
+		if err := pressKey(env, b, key, true); err != nil {
+			return nil, err
+		}
+		env.sched.Wait(time.Duration(hold)*time.Millisecond, env.expired) // 松开时也要完成这次单击
+		return starlark.None, pressKey(env, b, key, false)
+	}),
+	"move": builtin("move", func(env *scriptEnv, thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var x, y int
+		if err := starlark.UnpackArgs(b.Name(), args, kwargs, "x", &x, "y", &y); err != nil {
+			return nil, err
+		}
+		return starlark.None, env.mk.MouseMove(int32(x), int32(y), 0)
+	}),
+	"wheel": builtin("wheel", func(env *scriptEnv, thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var n int
+		if err := starlark.UnpackArgs(b.Name(), args, kwargs, "n", &n); err != nil {
+			return nil, err
+		}
+		return starlark.None, env.mk.MouseMove(0, 0, int32(n))
+	}),
+	"wait": builtin("wait", func(env *scriptEnv, thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var ms int
+		if err := starlark.UnpackArgs(b.Name(), args, kwargs, "ms", &ms); err != nil {
+			return nil, err
+		}
+		return starlark.Bool(scriptWait(env, ms)), nil
+	}),
+	"released": builtin("released", func(env *scriptEnv, thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		if err := starlark.UnpackArgs(b.Name(), args, kwargs); err != nil {
+			return nil, err
+		}
+		return starlark.Bool(env.isReleased()), nil
+	}),
+	"is_pressed": builtin("is_pressed", func(env *scriptEnv, thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var key string
+		if err := starlark.UnpackArgs(b.Name(), args, kwargs, "key", &key); err != nil {
+			return nil, err
+		}
+		code, isMouse, err := parseScriptKey(b, key)
+		if err != nil {
+			return nil, err
+		}
+		if isMouse {
+			return starlark.Bool(env.mk.Ctrl.IsMouseBtnPressed(code)), nil // 目标端的按键状态
+		}
+		return starlark.Bool(env.mk.isKeyHeld(code)), nil // 物理键盘的按键状态
+	}),
+	"type_text": builtin("type_text", func(env *scriptEnv, thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var text, layout string
+		delay := 0
+		if err := starlark.UnpackArgs(b.Name(), args, kwargs, "text", &text, "layout?", &layout, "delay?", &delay); err != nil {
+			return nil, err
+		}
+		err := env.mk.TypeText(text, layout, time.Duration(delay)*time.Millisecond, env.ctx.Done())
+		env.sched.Reset()
+		return starlark.None, err
+	}),
+	"arg": builtin("arg", func(env *scriptEnv, thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var name string
+		if err := starlark.UnpackArgs(b.Name(), args, kwargs, "name", &name); err != nil {
+			return nil, err
+		}
+		macroArgs := ArgsFromContext(env.ctx)
+		switch v := macroArgs[name].(type) {
+		case nil:
+			return nil, fmt.Errorf("%s: unknown parameter %q", b.Name(), name)
+		case int:
+			return starlark.MakeInt(v), nil
+		case float64:
+			return starlark.Float(v), nil
+		case bool:
+			return starlark.Bool(v), nil
+		case time.Duration:
+			return starlark.MakeInt64(v.Milliseconds()), nil // 同 wait，以毫秒为单位
+		default:
+			return starlark.String(macroArgs.Text(name)), nil
+		}
+	}),
+	"call": builtin("call", func(env *scriptEnv, thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		if len(args) == 0 {
+			return nil, fmt.Errorf("%s: missing macro name", b.Name())
+		}
+		name, ok := starlark.AsString(args[0])
+		if !ok {
+			return nil, fmt.Errorf("%s: macro name must be a string", b.Name())
+		}
+		raw := make(map[string]string, len(args)-1+len(kwargs))
+		for i, v := range args[1:] {
+			raw[strconv.Itoa(i)] = scriptText(v)
+		}
+		for _, kv := range kwargs {
+			raw[string(kv[0].(starlark.String))] = scriptText(kv[1])
+		}
+		macro, ok := LookupMacro(name)
+		if !ok {
+			return nil, fmt.Errorf("%s: unknown macro %q", b.Name(), name)
+		}
+		macroArgs, err := macro.BindArgs(raw)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", b.Name(), err)
+		}
+		err = env.mk.callMacro(env.callCtx(), name, macro, macroArgs)
+		env.sched.Reset()
+		return starlark.None, err
+	}),
+}
@@ -0,0 +1,51 @@
+package macros
+
+import (
+	"context"
+	"input2com/internal/clock"
+	"input2com/internal/config"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestScriptLimits(t *testing.T) {
+	tests := []struct {
+		name   string
+		src    string
+		limits config.ScriptConfig
+	}{
+		{"steps are counted across waits", "def on_press():\n    while True:\n        wait(0)\n",
+			config.ScriptConfig{MaxSteps: 10000, MaxRunTime: 3600000}},
+		{"run time interrupts wait in on_release", "def on_press():\n    pass\ndef on_release():\n    wait(1000000000)\n",
+			config.ScriptConfig{MaxRunTime: 50}},
+	}
+	old := config.Cfg
+	defer func() { config.Cfg = old }()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config.Cfg = &config.Config{Scripts: tt.limits}
+			file := filepath.Join(t.TempDir(), "limit.star")
+			if err := os.WriteFile(file, []byte(tt.src), 0644); err != nil {
+				t.Fatal(err)
+			}
+			macro, err := loadScript(file, "limit", tt.limits.MaxSteps)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel() // 已经松开，on_press 结束后直接执行 on_release
+			done := make(chan struct{})
+			go func() {
+				macro.Run(ctx, &MacroMouseKeyboard{Clock: clock.Real})
+				close(done)
+			}()
+			select {
+			case <-done:
+			case <-time.After(5 * time.Second):
+				t.Fatal("script was not stopped")
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package macros
+
+import (
+	"input2com/internal/clock"
+	"input2com/internal/config"
+	"input2com/internal/input"
+	"input2com/internal/logger"
+	"strings"
+)
+
+// ParseTapHold 解析 tap-hold 绑定 th(单击, 长按)，如 th(esc, lctrl)、th(middle, mo(fn))。
+// 单击和长按可以是宏名、带参数的宏调用、层切换或按键名，按键名会转换为 key:xxx / mouse:xxx 的形式
+func ParseTapHold(binding string) (tap, hold string, ok bool) {
+	return parseTapHold(currentMacros(), binding)
+}
+
+// parseTapHold 宏名从 macros 中查找，用于已持有 KeyboarddictMutex 的调用方
+func parseTapHold(macros map[string]Macro, binding string) (tap, hold string, ok bool) {
+	binding = strings.TrimSpace(binding)
+	if !strings.HasPrefix(binding, "th(") || !strings.HasSuffix(binding, ")") {
+		return "", "", false
+	}
+	parts, err := splitArgs(binding[3 : len(binding)-1])
+	if err != nil || len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", false
+	}
+	return normalizeBinding(macros, parts[0]), normalizeBinding(macros, parts[1]), true
+}
+
+func normalizeBinding(macros map[string]Macro, binding string) string {
+	if _, _, ok := ParseLayerAction(binding); ok {
+		return binding
+	}
+	if _, _, ok := parseKeyBinding(binding); ok {
+		return binding
+	}
+	if _, ok := macros[binding]; ok {
+		return binding
+	}
+	if _, isMouse, ok := input.ParseKey(binding); ok {
+		if isMouse {
+			return "mouse:" + binding
+		}
+		return "key:" + binding
+	}
+	return binding
+}
+
+// tapHoldPending 等待判定的 tap-hold 按键，判定前的其他按键事件缓存起来，判定后按原顺序处理
+type tapHoldPending struct {
+	ev      keyEvent
+	tap     string
+	hold    string
+	buffer  []keyEvent
+	pressed map[bindingKey]bool // 判定期间按下的按键，用于 permissive hold
+	timer   clock.Timer
+}
+
+// processTapHold tap-hold 处理，调用时需持有 inputMutex
+func (mk *MacroMouseKeyboard) processTapHold(ev keyEvent) error {
+	if p := mk.tapHold; p != nil {
+		if !ev.down && ev.key() == p.ev.key() {
+			mk.decideTapHold(false) // 判定时间内松开，为单击
+			return nil
+		}
+		p.buffer = append(p.buffer, ev)
+		switch {
+		case ev.down:
+			p.pressed[ev.key()] = true
+			if config.GetHoldOnOtherKeyPress() {
+				mk.decideTapHold(true)
+			}
+		case p.pressed[ev.key()] && config.GetPermissiveHold():
+			mk.decideTapHold(true) // 其他按键完整地按下又松开
+		}
+		return nil
+	}
+	if ev.down {
+		if binding, ok := resolveBinding(ev.devName, ev.isMouse, ev.code, false); ok {
+			if tap, hold, ok := ParseTapHold(binding); ok {
+				p := &tapHoldPending{ev: ev, tap: tap, hold: hold, pressed: make(map[bindingKey]bool)}
+				mk.tapHold = p
+				p.timer = clock.AfterFunc(mk.Clock, config.GetTappingTerm(), func() {
+					mk.inputMutex.Lock()
+					defer mk.inputMutex.Unlock()
+					if mk.tapHold == p {
+						mk.decideTapHold(true) // 超过判定时间，为长按
+					}
+				})
+				return nil
+			}
+		}
+	} else if hold, ok := mk.holding[ev.key()]; ok {
+		delete(mk.holding, ev.key())
+		mk.runBinding(ev, hold)
+		return nil
+	}
+	return mk.dispatch(ev)
+}
+
+// decideTapHold 完成当前 tap-hold 的判定，然后按顺序处理缓存的事件
+func (mk *MacroMouseKeyboard) decideTapHold(hold bool) {
+	p := mk.tapHold
+	mk.tapHold = nil
+	p.timer.Stop()
+	logger.Logger.Debugf("tap-hold 0x%02X 判定为长按: %t", p.ev.code, hold)
+	if hold {
+		mk.holding[p.ev.key()] = p.hold
+		mk.runBinding(p.ev, p.hold)
+	} else {
+		up := p.ev
+		up.down = false
+		mk.runBinding(p.ev, p.tap)
+		mk.sleep(config.GetTapDuration()) // 按下和松开挨在一起时有的目标端收不到单击
+		clock.Idle(mk.Clock)
+		mk.runBinding(up, p.tap)
+	}
+	for _, ev := range p.buffer {
+		mk.processTapHold(ev) // 缓存中可能有新的 tap-hold 按键
+	}
+}
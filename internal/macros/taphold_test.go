@@ -0,0 +1,111 @@
+package macros
+
+import (
+	"input2com/internal/clock"
+	"input2com/internal/config"
+	"slices"
+	"testing"
+	"time"
+)
+
+const (
+	evCaps = 58 // evdev KEY_CAPSLOCK, HID 0x39
+	evA    = 30 // evdev KEY_A, HID 0x04
+)
+
+// bindKeys 临时替换键盘绑定，测试结束后恢复
+func bindKeys(t *testing.T, bindings map[byte]string) {
+	old := KeyboardConfigDict
+	KeyboardConfigDict = bindings
+	t.Cleanup(func() { KeyboardConfigDict = old })
+}
+
+// withConfig 临时替换配置
+func withConfig(t *testing.T, cfg *config.Config) {
+	old := config.Cfg
+	config.Cfg = cfg
+	t.Cleanup(func() { config.Cfg = old })
+}
+
+// waitCall 等待控制器收到 call(不清空记录)
+func (f *fakeCtrl) waitCall(t *testing.T, call string) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		f.mutex.Lock()
+		found := slices.Contains(f.calls, call)
+		f.mutex.Unlock()
+		if found {
+			return
+		}
+	}
+	t.Fatalf("controller never got %q", call)
+}
+
+// fakeKB 在 Fake 时钟上创建，控制器记录从 0 开始的毫秒数
+func fakeKB() (*MacroMouseKeyboard, *fakeCtrl, *clock.Fake) {
+	start := time.Unix(0, 0)
+	fc := clock.NewFake(start)
+	ctrl := &fakeCtrl{clock: fc, start: start}
+	return NewMacroMouseKeyboardWithClock(ctrl, fc), ctrl, fc
+}
+
+func TestTapHold(t *testing.T) {
+	bindKeys(t, map[byte]string{0x39: "th(esc, lctrl)"})
+	tests := []struct {
+		name string
+		cfg  config.TapHoldConfig
+		run  func(mk *MacroMouseKeyboard, ctrl *fakeCtrl, fc *clock.Fake)
+		want []string
+	}{
+		{"released within term is a tap held for tapDuration", config.TapHoldConfig{}, func(mk *MacroMouseKeyboard, ctrl *fakeCtrl, fc *clock.Fake) {
+			mk.KeyDown(evCaps, "kbd")
+			fc.Advance(50 * time.Millisecond)
+			done := make(chan struct{})
+			go func() { mk.KeyUp(evCaps, "kbd"); close(done) }()
+			ctrl.waitCall(t, "50ms kdown 0x29")
+			fc.BlockUntil(1)
+			fc.Advance(10 * time.Millisecond)
+			<-done
+		}, []string{"50ms kdown 0x29", "60ms kup 0x29"}},
+		{"held past term is a hold", config.TapHoldConfig{}, func(mk *MacroMouseKeyboard, ctrl *fakeCtrl, fc *clock.Fake) {
+			mk.KeyDown(evCaps, "kbd")
+			fc.Advance(250 * time.Millisecond)
+			mk.KeyUp(evCaps, "kbd")
+		}, []string{"200ms kdown 0xe0", "250ms kup 0xe0"}},
+		{"other key is buffered until the tap", config.TapHoldConfig{TapDuration: 5}, func(mk *MacroMouseKeyboard, ctrl *fakeCtrl, fc *clock.Fake) {
+			mk.KeyDown(evCaps, "kbd")
+			mk.KeyDown(evA, "kbd")
+			done := make(chan struct{})
+			go func() { mk.KeyUp(evCaps, "kbd"); close(done) }()
+			ctrl.waitCall(t, "0ms kdown 0x29")
+			fc.BlockUntil(1)
+			fc.Advance(5 * time.Millisecond)
+			<-done
+			mk.KeyUp(evA, "kbd")
+		}, []string{"0ms kdown 0x29", "5ms kup 0x29", "5ms kdown 0x4", "5ms kup 0x4"}},
+		{"hold on other key press", config.TapHoldConfig{HoldOnOtherKeyPress: true}, func(mk *MacroMouseKeyboard, ctrl *fakeCtrl, fc *clock.Fake) {
+			mk.KeyDown(evCaps, "kbd")
+			mk.KeyDown(evA, "kbd")
+			mk.KeyUp(evA, "kbd")
+			mk.KeyUp(evCaps, "kbd")
+		}, []string{"0ms kdown 0xe0", "0ms kdown 0x4", "0ms kup 0x4", "0ms kup 0xe0"}},
+		{"permissive hold on a full tap of another key", config.TapHoldConfig{PermissiveHold: true}, func(mk *MacroMouseKeyboard, ctrl *fakeCtrl, fc *clock.Fake) {
+			mk.KeyDown(evCaps, "kbd")
+			mk.KeyDown(evA, "kbd")
+			fc.Advance(20 * time.Millisecond)
+			mk.KeyUp(evA, "kbd")
+			mk.KeyUp(evCaps, "kbd")
+		}, []string{"20ms kdown 0xe0", "20ms kdown 0x4", "20ms kup 0x4", "20ms kup 0xe0"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withConfig(t, &config.Config{TapHold: tt.cfg})
+			mk, ctrl, fc := fakeKB()
+			tt.run(mk, ctrl, fc)
+			expectCalls(t, ctrl, tt.want...)
+			if mk.tapHold != nil {
+				t.Error("tap-hold still pending")
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package macros
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// TargetCtrl 包装控制器，输出目标可以通过 MacroMouseKeyboard.SwitchTarget 切换
+type TargetCtrl struct {
+	current atomic.Pointer[MouseCtrl]
+}
+
+func NewTargetCtrl(ctrl MouseCtrl) *TargetCtrl {
+	t := &TargetCtrl{}
+	t.current.Store(&ctrl)
+	return t
+}
+
+// Unwrap 返回当前的输出目标
+func (t *TargetCtrl) Unwrap() MouseCtrl {
+	return *t.current.Load()
+}
+
+func (t *TargetCtrl) MouseBtnDown(keyCode byte) error     { return t.Unwrap().MouseBtnDown(keyCode) }
+func (t *TargetCtrl) MouseBtnUp(keyCode byte) error       { return t.Unwrap().MouseBtnUp(keyCode) }
+func (t *TargetCtrl) MouseMove(dx, dy, wheel int32) error { return t.Unwrap().MouseMove(dx, dy, wheel) }
+func (t *TargetCtrl) IsMouseBtnPressed(keyCode byte) bool {
+	return t.Unwrap().IsMouseBtnPressed(keyCode)
+}
+func (t *TargetCtrl) KeyDown(keyCode byte) error           { return t.Unwrap().KeyDown(keyCode) }
+func (t *TargetCtrl) KeyUp(keyCode byte) error             { return t.Unwrap().KeyUp(keyCode) }
+func (t *TargetCtrl) LockMouse(Button int, lock int) error { return t.Unwrap().LockMouse(Button, lock) }
+func (t *TargetCtrl) Click(i int) error                    { return t.Unwrap().Click(i) }
+func (t *TargetCtrl) MouseBtnMask() byte                   { return t.Unwrap().MouseBtnMask() }
+
+// SwitchTarget 切换输出目标：先在旧目标上松开所有按键、停止宏并发送释放报告，之后的输出发给 ctrl。
+// mk.Ctrl 需要包含 TargetCtrl(外面可以再包 TimedCtrl)
+func (mk *MacroMouseKeyboard) SwitchTarget(ctrl MouseCtrl) error {
+	target, ok := unwrapCtrl[*TargetCtrl](mk.Ctrl)
+	if !ok {
+		return fmt.Errorf("controller does not support switching targets")
+	}
+	mk.inputMutex.Lock()
+	defer mk.inputMutex.Unlock()
+	mk.markStale() // 仍按着的按键松开时不发给新目标
+	mk.releaseAllLocked()
+	target.current.Store(&ctrl)
+	return nil
+}
@@ -0,0 +1,25 @@
+package macros
+
+import (
+	"input2com/internal/clock"
+	"reflect"
+	"testing"
+)
+
+func TestSwitchTarget(t *testing.T) {
+	old, next := &fakeCtrl{}, &fakeCtrl{}
+	mk := NewMacroMouseKeyboardWithClock(NewTimedCtrl(NewTargetCtrl(old), "test"), clock.Real)
+	mk.KeyDown(evA, "kbd")
+	if err := mk.SwitchTarget(next); err != nil {
+		t.Fatal(err)
+	}
+	mk.KeyUp(evA, "kbd") // 已在旧目标上松开
+	mk.KeyDown(evA, "kbd")
+
+	if got, want := old.Calls(), []string{"kdown 0x4", "kup 0x4"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("old target got %q, want %q", got, want)
+	}
+	if got, want := next.Calls(), []string{"kdown 0x4"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("new target got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,65 @@
+package macros
+
+import (
+	"input2com/internal/latency"
+	"time"
+)
+
+// TimedCtrl 包装控制器，记录每次写入后端的耗时
+type TimedCtrl struct {
+	MouseCtrl
+	name string
+}
+
+func NewTimedCtrl(ctrl MouseCtrl, name string) *TimedCtrl {
+	return &TimedCtrl{MouseCtrl: ctrl, name: name}
+}
+
+// Unwrap 返回被包装的控制器
+func (t *TimedCtrl) Unwrap() MouseCtrl {
+	return t.MouseCtrl
+}
+
+func (t *TimedCtrl) observe(start time.Time) {
+	latency.Observe(latency.KindBackend, t.name, time.Since(start))
+}
+
+func (t *TimedCtrl) MouseBtnDown(keyCode byte) error {
+	defer t.observe(time.Now())
+	return t.MouseCtrl.MouseBtnDown(keyCode)
+}
+
+func (t *TimedCtrl) MouseBtnUp(keyCode byte) error {
+	defer t.observe(time.Now())
+	return t.MouseCtrl.MouseBtnUp(keyCode)
+}
+
+func (t *TimedCtrl) MouseMove(dx, dy, wheel int32) error {
+	defer t.observe(time.Now())
+	return t.MouseCtrl.MouseMove(dx, dy, wheel)
+}
+
+func (t *TimedCtrl) KeyDown(keyCode byte) error {
+	defer t.observe(time.Now())
+	return t.MouseCtrl.KeyDown(keyCode)
+}
+
+func (t *TimedCtrl) KeyUp(keyCode byte) error {
+	defer t.observe(time.Now())
+	return t.MouseCtrl.KeyUp(keyCode)
+}
+
+// unwrapCtrl 穿过 TimedCtrl 等包装查找实现了某个可选接口的控制器
+func unwrapCtrl[T any](ctrl MouseCtrl) (T, bool) {
+	for {
+		if found, ok := ctrl.(T); ok {
+			return found, true
+		}
+		wrapper, ok := ctrl.(interface{ Unwrap() MouseCtrl })
+		if !ok {
+			var zero T
+			return zero, false
+		}
+		ctrl = wrapper.Unwrap()
+	}
+}
@@ -0,0 +1,55 @@
+package macros
+
+import (
+	"input2com/internal/config"
+	"input2com/internal/input"
+	"time"
+)
+
+// TypeText 在目标端输入文本，layout 为空时使用配置的布局，delay <= 0 时使用配置的间隔。
+// 文本中有无法输入的字符时不输入任何内容并返回错误，cancel 关闭时停止输入
+func (mk *MacroMouseKeyboard) TypeText(text, layout string, delay time.Duration, cancel <-chan struct{}) error {
+	strokes, err := ParseText(text, layout)
+	if err != nil {
+		return err
+	}
+	if delay <= 0 {
+		_, delay = config.GetTyper()
+	}
+	sched := mk.scheduler()
+	wait := func() bool {
+		return sched.Wait(delay, cancel)
+	}
+	for _, s := range strokes {
+		for _, m := range s.Modifiers {
+			if err := mk.Ctrl.KeyDown(m); err != nil {
+				return err
+			}
+		}
+		err := mk.Ctrl.KeyDown(s.Key)
+		ok := err == nil && wait()
+		mk.Ctrl.KeyUp(s.Key)
+		for _, m := range s.Modifiers {
+			mk.Ctrl.KeyUp(m)
+		}
+		if err != nil {
+			return err
+		}
+		if !ok || !wait() {
+			return nil
+		}
+	}
+	return nil
+}
+
+// ParseText 按布局将文本转换为按键序列
+func ParseText(text, layout string) ([]input.Stroke, error) {
+	if layout == "" {
+		layout, _ = config.GetTyper()
+	}
+	l, err := input.GetLayout(layout)
+	if err != nil {
+		return nil, err
+	}
+	return l.Strokes(text)
+}
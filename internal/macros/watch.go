@@ -0,0 +1,142 @@
+package macros
+
+import (
+	"errors"
+	"input2com/internal/config"
+	"input2com/internal/logger"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// 编辑器保存文件时可能连续产生多个事件，等待一段时间没有新事件后再重新加载
+const reloadDebounce = 200 * time.Millisecond
+
+// watchTarget 监听的目录，目录下匹配的文件变化时调用 reload
+type watchTarget struct {
+	dir    string
+	match  func(file string) bool
+	reload func()
+
+	watching bool // 已经在监听 dir 本身，为 false 时在等待 dir 被创建
+}
+
+// watchDir 监听 dir，dir 还不存在时监听最近的已存在的上级目录，等 dir 被创建后再添加。
+// 返回是否已经在监听 dir 本身
+func watchDir(watcher *fsnotify.Watcher, dir string) bool {
+	dir = filepath.Clean(dir)
+	for d := dir; ; {
+		err := watcher.Add(d)
+		if err == nil {
+			if d != dir {
+				logger.Logger.Infof("%s 不存在，创建后开始监听", dir)
+			}
+			return d == dir
+		}
+		parent := filepath.Dir(d)
+		if !errors.Is(err, fs.ErrNotExist) || parent == d {
+			logger.Logger.Warnf("无法监听 %s: %v", dir, err)
+			return false
+		}
+		d = parent
+	}
+}
+
+// isAncestor 判断 dir 是否为 file 本身或在 file 下
+func isAncestor(file, dir string) bool {
+	return dir == file || strings.HasPrefix(dir, file+string(filepath.Separator))
+}
+
+// Watch 监听压枪配置、宏目录和脚本目录，文件变化后重新加载对应的宏，直到 stop 关闭。
+// 加载错误写入日志并可通过 GetLoadStatus 查看，出错的文件保留原来的宏
+func Watch(stop <-chan bool) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	hasExt := func(exts ...string) func(string) bool {
+		return func(file string) bool {
+			ext := filepath.Ext(file)
+			for _, e := range exts {
+				if ext == e {
+					return true
+				}
+			}
+			return false
+		}
+	}
+	macrosDir := config.GetMacrosDir()
+	scriptsDir, _, _ := config.GetScriptLimits()
+	targets := []watchTarget{
+		{
+			dir:   filepath.Dir(RecoilFile),
+			match: func(file string) bool { return file == filepath.Clean(RecoilFile) },
+			reload: func() {
+				if err := LoadRecoils(RecoilFile); err != nil {
+					logger.Logger.Errorf("重新加载压枪宏失败: %v", err)
+				}
+			},
+		},
+		{dir: macrosDir, match: hasExt(".yaml", ".yml", ".json"), reload: func() { LoadMacroDir(macrosDir) }},
+		{dir: scriptsDir, match: hasExt(".star"), reload: func() { LoadScripts() }},
+	}
+	for i := range targets {
+		targets[i].watching = watchDir(watcher, targets[i].dir)
+	}
+
+	go func() {
+		defer watcher.Close()
+		pending := make(map[int]bool)
+		var timer <-chan time.Time
+		for {
+			select {
+			case <-stop:
+				return
+			case ev, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if ev.Has(fsnotify.Chmod) && !ev.Has(fsnotify.Write) {
+					continue
+				}
+				file := filepath.Clean(ev.Name)
+				for i := range targets {
+					t := &targets[i]
+					switch {
+					case !t.watching && ev.Has(fsnotify.Create) && isAncestor(file, filepath.Clean(t.dir)):
+						// 目录或它的上级被创建，目录中可能已经有文件，添加后重新加载
+						if t.watching = watchDir(watcher, t.dir); t.watching {
+							pending[i] = true
+							timer = time.After(reloadDebounce)
+						}
+					case t.watching && (ev.Has(fsnotify.Remove) || ev.Has(fsnotify.Rename)) && file == filepath.Clean(t.dir):
+						watcher.Remove(t.dir) // 目录被删除或移走，等待重新创建
+						t.watching = watchDir(watcher, t.dir)
+					}
+				}
+				for i, t := range targets {
+					if filepath.Dir(file) == filepath.Clean(t.dir) && t.match(file) {
+						pending[i] = true
+						timer = time.After(reloadDebounce)
+					}
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				logger.Logger.Errorf("监听宏文件出错: %v", err)
+			case <-timer:
+				for i := range pending {
+					logger.Logger.Infof("%s 有变化，重新加载", targets[i].dir)
+					targets[i].reload()
+				}
+				pending = make(map[int]bool)
+				timer = nil
+			}
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,54 @@
+package macros
+
+import (
+	"input2com/internal/config"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// waitMacro 等待宏被加载或移除
+func waitMacro(t *testing.T, name string, loaded bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if _, ok := LookupMacro(name); ok == loaded {
+			return
+		}
+	}
+	t.Fatalf("macro %s loaded: %t, want %t", name, !loaded, loaded)
+}
+
+func TestWatchCreatedDir(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "config", "macros") // 两级都还不存在
+	withConfig(t, &config.Config{MacrosDir: dir, Scripts: config.ScriptConfig{Dir: filepath.Join(root, "scripts")}})
+	stop := make(chan bool)
+	defer close(stop)
+	if err := Watch(stop); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, "test.yaml")
+	t.Cleanup(func() { setSource(macroSource{kindFile, file}, nil) })
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(file, []byte("- name: test_watch_created\n  steps: [{tap: a}]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	waitMacro(t, "test_watch_created", true)
+
+	// 删除后重新创建也能继续监听
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+	waitMacro(t, "test_watch_created", false)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(file, []byte("- name: test_watch_recreated\n  steps: [{tap: a}]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	waitMacro(t, "test_watch_recreated", true)
+}
@@ -0,0 +1,120 @@
+package pipeline
+
+import (
+	"input2com/internal/latency"
+	"input2com/internal/logger"
+	"sync"
+	"time"
+
+	"github.com/kenshaw/evdev"
+)
+
+// Event 单个输入事件，与 evdev 事件一一对应
+type Event struct {
+	Type  evdev.EventType
+	Code  uint16
+	Value int32
+}
+
+// Packet 表示一个动作，由两个 SYN_REPORT 之间的一系列事件组成
+type Packet struct {
+	Device string
+	Events []Event
+	Time   time.Time // SYN_REPORT 的内核时间戳，用于统计端到端延迟
+}
+
+// Rel 返回包中某个相对轴的值，不存在时返回0
+func (p *Packet) Rel(code evdev.RelativeType) int32 {
+	for _, e := range p.Events {
+		if e.Type == evdev.EventRelative && e.Code == uint16(code) {
+			return e.Value
+		}
+	}
+	return 0
+}
+
+// SetRel 修改包中某个相对轴的值，不存在且值不为0时追加
+func (p *Packet) SetRel(code evdev.RelativeType, value int32) {
+	for i, e := range p.Events {
+		if e.Type == evdev.EventRelative && e.Code == uint16(code) {
+			p.Events[i].Value = value
+			return
+		}
+	}
+	if value != 0 {
+		p.Events = append(p.Events, Event{Type: evdev.EventRelative, Code: uint16(code), Value: value})
+	}
+}
+
+// Stage 处理阶段，返回 false 表示丢弃该包，后续阶段不再处理
+type Stage interface {
+	Name() string
+	Process(p *Packet) bool
+}
+
+type stageFunc struct {
+	name string
+	fn   func(p *Packet) bool
+}
+
+func (s *stageFunc) Name() string           { return s.name }
+func (s *stageFunc) Process(p *Packet) bool { return s.fn(p) }
+
+// StageFunc 用函数构造一个处理阶段
+func StageFunc(name string, fn func(p *Packet) bool) Stage {
+	return &stageFunc{name: name, fn: fn}
+}
+
+// Pipeline 按顺序执行的一组处理阶段
+type Pipeline struct {
+	stages []Stage
+	mutex  sync.RWMutex
+}
+
+func New(stages ...Stage) *Pipeline {
+	return &Pipeline{stages: stages}
+}
+
+// Insert 在名为 before 的阶段前插入新阶段，找不到时追加到末尾
+func (pl *Pipeline) Insert(before string, stage Stage) {
+	pl.mutex.Lock()
+	defer pl.mutex.Unlock()
+	for i, s := range pl.stages {
+		if s.Name() == before {
+			pl.stages = append(pl.stages[:i], append([]Stage{stage}, pl.stages[i:]...)...)
+			return
+		}
+	}
+	pl.stages = append(pl.stages, stage)
+}
+
+// Stages 返回当前各阶段名称
+func (pl *Pipeline) Stages() []string {
+	pl.mutex.RLock()
+	defer pl.mutex.RUnlock()
+	names := make([]string, 0, len(pl.stages))
+	for _, s := range pl.stages {
+		names = append(names, s.Name())
+	}
+	return names
+}
+
+// Run 依次执行各阶段
+func (pl *Pipeline) Run(p *Packet) {
+	pl.mutex.RLock()
+	defer pl.mutex.RUnlock()
+	perfPoint := time.Now()
+	for _, s := range pl.stages {
+		ok := s.Process(p)
+		now := time.Now()
+		latency.Observe(latency.KindStage, s.Name(), now.Sub(perfPoint))
+		logger.Logger.Debugf("handel %s\t%v", s.Name(), now.Sub(perfPoint))
+		perfPoint = now
+		if !ok {
+			return
+		}
+	}
+	if !p.Time.IsZero() { // 转发的报告在输出阶段同步写入后端，这里即内核时间戳到写入完成
+		latency.Observe(latency.KindDevice, p.Device, perfPoint.Sub(p.Time))
+	}
+}
@@ -0,0 +1,121 @@
+package pipeline
+
+import (
+	"input2com/internal/input"
+	"input2com/internal/sensitivity"
+	"strings"
+
+	"github.com/kenshaw/evdev"
+)
+
+// Dispatcher 宏分发和输出的目标，由 macros.MacroMouseKeyboard 实现
+type Dispatcher interface {
+	MouseBtnDown(keyCode byte, devName string) error
+	MouseBtnUp(keyCode byte, devName string) error
+	KeyDown(keyCode uint16, devName string) error
+	KeyUp(keyCode uint16, devName string) error
+	KeyRepeat(keyCode uint16) error
+	MouseMove(dx, dy, wheel int32) error
+}
+
+// NewDeviceFilter 丢弃来自忽略列表中设备的事件，设备名不区分大小写
+func NewDeviceFilter(ignore []string) Stage {
+	ignored := make(map[string]bool)
+	for _, name := range ignore {
+		ignored[strings.ToLower(name)] = true
+	}
+	return StageFunc("filter", func(p *Packet) bool {
+		return !ignored[strings.ToLower(p.Device)]
+	})
+}
+
+// NewRemap 按设备重映射按键码(evdev)，设备名为小写。"default" 对所有设备生效，
+// 设备自己的表在其基础上覆盖同一按键，没有覆盖的按键仍按 default 映射
+func NewRemap(remap map[string]map[uint16]uint16) Stage {
+	return StageFunc("remap", func(p *Packet) bool {
+		table, def := remap[strings.ToLower(p.Device)], remap["default"]
+		if len(table) == 0 && len(def) == 0 {
+			return true
+		}
+		for i, e := range p.Events {
+			if e.Type != evdev.EventKey {
+				continue
+			}
+			if to, ok := table[e.Code]; ok {
+				p.Events[i].Code = to
+			} else if to, ok := def[e.Code]; ok {
+				p.Events[i].Code = to
+			}
+		}
+		return true
+	})
+}
+
+// NewSensitivity 按设备缩放相对移动
+func NewSensitivity() Stage {
+	return StageFunc("sensitivity", func(p *Packet) bool {
+		x, y := p.Rel(evdev.RelativeX), p.Rel(evdev.RelativeY)
+		if x == 0 && y == 0 {
+			return true
+		}
+		x, y = sensitivity.Apply(p.Device, x, y)
+		p.SetRel(evdev.RelativeX, x)
+		p.SetRel(evdev.RelativeY, y)
+		return true
+	})
+}
+
+// NewMacroDispatch 将按键事件交给宏分发，鼠标按键和键盘按键分别处理
+func NewMacroDispatch(d Dispatcher) Stage {
+	return StageFunc("dispatch", func(p *Packet) bool {
+		for _, e := range p.Events {
+			if e.Type != evdev.EventKey {
+				continue
+			}
+			btn, isMouseBtn := input.EvdevMouseBtns[e.Code]
+			switch e.Value {
+			case 0:
+				if isMouseBtn { // 鼠标按键释放
+					d.MouseBtnUp(btn, p.Device)
+				} else {
+					d.KeyUp(e.Code, p.Device) // 其他按键释放
+				}
+			case 1:
+				if isMouseBtn { // 鼠标按键按下
+					d.MouseBtnDown(btn, p.Device)
+				} else {
+					d.KeyDown(e.Code, p.Device) // 其他按键按下
+				}
+			case 2:
+				d.KeyRepeat(e.Code)
+			}
+		}
+		return true
+	})
+}
+
+// NewOutput 将相对移动和滚轮输出到控制器，横向滚轮只在后端支持时输出，后端支持高精度滚轮时另外传递高精度值。
+// 放在宏分发之前，同一个包中的移动先于按键输出，与目标端收到的鼠标报告顺序一致
+func NewOutput(d Dispatcher) Stage {
+	hWheel, ok := d.(HWheelDispatcher)
+	if ok && !hWheel.SupportsHWheel() {
+		hWheel = nil
+	}
+	hiRes := hiResDispatcher(d)
+	return StageFunc("output", func(p *Packet) bool {
+		x, y := p.Rel(evdev.RelativeX), p.Rel(evdev.RelativeY)
+		wheel := p.Rel(evdev.RelativeWheel)
+		if x != 0 || y != 0 || wheel != 0 {
+			d.MouseMove(x, y, wheel)
+		}
+		if h := p.Rel(evdev.RelativeHWheel); h != 0 && hWheel != nil {
+			hWheel.MouseHWheel(h)
+		}
+		if hiRes != nil {
+			if v, h := p.Rel(RelativeWheelHiRes), p.Rel(RelativeHWheelHiRes); v != 0 || h != 0 {
+				hiRes.MouseWheelHiRes(v, h)
+			}
+		}
+		return true
+	})
+}
@@ -0,0 +1,241 @@
+package pipeline
+
+import (
+	"fmt"
+	"input2com/internal/config"
+	"input2com/internal/input"
+	"input2com/internal/sensitivity"
+	"reflect"
+	"testing"
+
+	"github.com/kenshaw/evdev"
+)
+
+func key(code uint16, value int32) Event {
+	return Event{Type: evdev.EventKey, Code: code, Value: value}
+}
+
+func rel(code evdev.RelativeType, value int32) Event {
+	return Event{Type: evdev.EventRelative, Code: uint16(code), Value: value}
+}
+
+// recorder 记录 Dispatcher 收到的调用
+type recorder struct {
+	calls []string
+}
+
+func (r *recorder) MouseBtnDown(keyCode byte, devName string) error {
+	r.calls = append(r.calls, fmt.Sprintf("mdown %s 0x%02x", devName, keyCode))
+	return nil
+}
+
+func (r *recorder) MouseBtnUp(keyCode byte, devName string) error {
+	r.calls = append(r.calls, fmt.Sprintf("mup %s 0x%02x", devName, keyCode))
+	return nil
+}
+
+func (r *recorder) KeyDown(keyCode uint16, devName string) error {
+	r.calls = append(r.calls, fmt.Sprintf("kdown %s %d", devName, keyCode))
+	return nil
+}
+
+func (r *recorder) KeyUp(keyCode uint16, devName string) error {
+	r.calls = append(r.calls, fmt.Sprintf("kup %s %d", devName, keyCode))
+	return nil
+}
+
+func (r *recorder) KeyRepeat(keyCode uint16) error {
+	r.calls = append(r.calls, fmt.Sprintf("repeat %d", keyCode))
+	return nil
+}
+
+func (r *recorder) MouseMove(dx, dy, wheel int32) error {
+	r.calls = append(r.calls, fmt.Sprintf("move %d %d %d", dx, dy, wheel))
+	return nil
+}
+
+// hWheelRecorder 支持横向滚轮的后端
+type hWheelRecorder struct {
+	recorder
+	supported bool
+}
+
+func (r *hWheelRecorder) SupportsHWheel() bool {
+	return r.supported
+}
+
+func (r *hWheelRecorder) MouseHWheel(steps int32) error {
+	r.calls = append(r.calls, fmt.Sprintf("hwheel %d", steps))
+	return nil
+}
+
+func TestRemap(t *testing.T) {
+	remap := map[string]map[uint16]uint16{
+		"default": {58: 29, 30: 31},
+		"kbd":     {58: 1},
+	}
+	tests := []struct {
+		name   string
+		device string
+		in     []Event
+		want   []Event
+	}{
+		{"default table", "other", []Event{key(58, 1), key(30, 0)}, []Event{key(29, 1), key(31, 0)}},
+		{"device overrides default", "KBD", []Event{key(58, 1)}, []Event{key(1, 1)}},
+		{"device falls back to default", "kbd", []Event{key(30, 1)}, []Event{key(31, 1)}},
+		{"unmapped key and relative events untouched", "kbd", []Event{key(2, 1), rel(evdev.RelativeX, 58)}, []Event{key(2, 1), rel(evdev.RelativeX, 58)}},
+	}
+	stage := NewRemap(remap)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &Packet{Device: tt.device, Events: tt.in}
+			if !stage.Process(p) {
+				t.Fatal("packet dropped")
+			}
+			if !reflect.DeepEqual(p.Events, tt.want) {
+				t.Errorf("got %v, want %v", p.Events, tt.want)
+			}
+		})
+	}
+}
+
+func TestSensitivity(t *testing.T) {
+	if err := sensitivity.Set("stage-test", config.SensitivityProfile{X: sensitivity.Ratio(2), Y: sensitivity.Ratio(0)}); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		device string
+		in     []Event
+		want   []Event
+	}{
+		{"scaled per device", "stage-test", []Event{rel(evdev.RelativeX, 3), rel(evdev.RelativeY, 4)}, []Event{rel(evdev.RelativeX, 6), rel(evdev.RelativeY, 0)}},
+		{"other devices use default", "stage-test-other", []Event{rel(evdev.RelativeX, 3), rel(evdev.RelativeY, 4)}, []Event{rel(evdev.RelativeX, 3), rel(evdev.RelativeY, 4)}},
+		{"no motion", "stage-test", []Event{key(30, 1)}, []Event{key(30, 1)}},
+		{"missing axis is added", "stage-test", []Event{rel(evdev.RelativeX, 1)}, []Event{rel(evdev.RelativeX, 2)}},
+	}
+	stage := NewSensitivity()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &Packet{Device: tt.device, Events: tt.in}
+			stage.Process(p)
+			if !reflect.DeepEqual(p.Events, tt.want) {
+				t.Errorf("got %v, want %v", p.Events, tt.want)
+			}
+		})
+	}
+}
+
+func TestHiResWheel(t *testing.T) {
+	stage := NewHiResWheel(&recorder{})
+	steps := []struct {
+		in    []Event
+		wheel int32
+	}{
+		{[]Event{rel(RelativeWheelHiRes, 60), rel(evdev.RelativeWheel, 0)}, 0},
+		{[]Event{rel(RelativeWheelHiRes, 60), rel(evdev.RelativeWheel, 1)}, 1}, // 两次半格合成一格
+		{[]Event{rel(RelativeWheelHiRes, -240), rel(evdev.RelativeWheel, -2)}, -2},
+		{[]Event{rel(evdev.RelativeWheel, 1)}, 1}, // 不上报高精度值的设备不受影响
+	}
+	for i, s := range steps {
+		p := &Packet{Device: "wheel-test", Events: s.in}
+		stage.Process(p)
+		if got := p.Rel(evdev.RelativeWheel); got != s.wheel {
+			t.Errorf("step %d: wheel = %d, want %d", i, got, s.wheel)
+		}
+	}
+}
+
+func TestHiResHWheel(t *testing.T) {
+	stage := NewHiResWheel(&recorder{})
+	var total int32
+	for i := 0; i < 5; i++ {
+		p := &Packet{Device: "hwheel-test", Events: []Event{rel(RelativeHWheelHiRes, 48)}}
+		stage.Process(p)
+		total += p.Rel(evdev.RelativeHWheel)
+	}
+	// 5*48 = 240，余数按设备保留，不会丢失
+	if total != 2 {
+		t.Errorf("hwheel total = %d, want 2", total)
+	}
+}
+
+// hiResRecorder 支持高精度滚轮的后端
+type hiResRecorder struct {
+	recorder
+}
+
+func (r *hiResRecorder) SupportsHiResWheel() bool {
+	return true
+}
+
+func (r *hiResRecorder) MouseWheelHiRes(vertical, horizontal int32) error {
+	r.calls = append(r.calls, fmt.Sprintf("hires %d %d", vertical, horizontal))
+	return nil
+}
+
+func TestHiResWheelPassthrough(t *testing.T) {
+	d := &hiResRecorder{}
+	pipe := New(NewHiResWheel(d), NewOutput(d))
+	pipe.Run(&Packet{Device: "dev", Events: []Event{rel(RelativeWheelHiRes, 30)}})
+	pipe.Run(&Packet{Device: "dev", Events: []Event{rel(RelativeWheelHiRes, 90), rel(evdev.RelativeWheel, 1), rel(RelativeHWheelHiRes, -120), rel(evdev.RelativeHWheel, -1)}})
+	pipe.Run(&Packet{Device: "dev", Events: []Event{rel(evdev.RelativeWheel, 1)}}) // 不上报高精度值的设备仍按整格
+	want := []string{"hires 30 0", "hires 90 -120", "move 0 0 1"}
+	if !reflect.DeepEqual(d.calls, want) {
+		t.Errorf("got %v, want %v", d.calls, want)
+	}
+}
+
+func TestOutput(t *testing.T) {
+	in := []Event{rel(evdev.RelativeX, 1), rel(evdev.RelativeWheel, 2), rel(evdev.RelativeHWheel, -1)}
+	tests := []struct {
+		name string
+		d    *hWheelRecorder
+		want []string
+	}{
+		{"backend with horizontal wheel", &hWheelRecorder{supported: true}, []string{"move 1 0 2", "hwheel -1"}},
+		{"horizontal wheel dropped", &hWheelRecorder{}, []string{"move 1 0 2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			NewOutput(tt.d).Process(&Packet{Device: "dev", Events: append([]Event(nil), in...)})
+			if !reflect.DeepEqual(tt.d.calls, tt.want) {
+				t.Errorf("got %v, want %v", tt.d.calls, tt.want)
+			}
+		})
+	}
+}
+
+func TestMacroDispatch(t *testing.T) {
+	tests := []struct {
+		name string
+		in   []Event
+		want []string
+	}{
+		{"mouse buttons", []Event{key(uint16(evdev.BtnLeft), 1), key(uint16(evdev.BtnSide), 0)},
+			[]string{fmt.Sprintf("mdown dev 0x%02x", input.MouseBtnLeft), fmt.Sprintf("mup dev 0x%02x", input.MouseBtnBack)}},
+		{"keyboard keys and repeat", []Event{key(30, 1), key(30, 2), key(30, 0)},
+			[]string{"kdown dev 30", "repeat 30", "kup dev 30"}},
+		{"relative events ignored", []Event{rel(evdev.RelativeX, 5)}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &recorder{}
+			NewMacroDispatch(r).Process(&Packet{Device: "dev", Events: tt.in})
+			if !reflect.DeepEqual(r.calls, tt.want) {
+				t.Errorf("got %v, want %v", r.calls, tt.want)
+			}
+		})
+	}
+}
+
+// TestMotionBeforeKeys 同一个包中的移动先于按键输出
+func TestMotionBeforeKeys(t *testing.T) {
+	r := &recorder{}
+	pl := New(NewOutput(r), NewMacroDispatch(r))
+	pl.Run(&Packet{Device: "dev", Events: []Event{key(uint16(evdev.BtnLeft), 1), rel(evdev.RelativeX, 3), rel(evdev.RelativeWheel, -1)}})
+	want := []string{"move 3 0 -1", fmt.Sprintf("mdown dev 0x%02x", input.MouseBtnLeft)}
+	if !reflect.DeepEqual(r.calls, want) {
+		t.Errorf("got %v, want %v", r.calls, want)
+	}
+}
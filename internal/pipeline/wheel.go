@@ -0,0 +1,86 @@
+package pipeline
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/kenshaw/evdev"
+)
+
+const (
+	RelativeWheelHiRes  = evdev.RelativeType(0x0b) // REL_WHEEL_HI_RES
+	RelativeHWheelHiRes = evdev.RelativeType(0x0c) // REL_HWHEEL_HI_RES
+	WheelHiResPerNotch  = 120                      // 每格对应的高精度单位
+)
+
+// HWheelDispatcher 可选接口，后端支持横向滚轮时输出 RelativeHWheel
+type HWheelDispatcher interface {
+	SupportsHWheel() bool
+	MouseHWheel(steps int32) error
+}
+
+// HiResWheelDispatcher 可选接口，后端支持高精度滚轮时直接传递 120单位/格 的值
+type HiResWheelDispatcher interface {
+	SupportsHiResWheel() bool
+	MouseWheelHiRes(vertical, horizontal int32) error
+}
+
+// hiResDispatcher 返回支持高精度滚轮的后端，不支持时返回 nil
+func hiResDispatcher(d Dispatcher) HiResWheelDispatcher {
+	if hiRes, ok := d.(HiResWheelDispatcher); ok && hiRes.SupportsHiResWheel() {
+		return hiRes
+	}
+	return nil
+}
+
+// NewHiResWheel 处理上报高精度滚轮的设备。后端支持高精度滚轮时保留高精度值由输出阶段直接传递，
+// 并去掉设备同时上报的普通滚轮事件，避免重复滚动；否则按设备累积换算为整格替换普通滚轮事件，
+// 不满一格的部分留到下次。不上报高精度滚轮的设备不受影响
+func NewHiResWheel(d Dispatcher) Stage {
+	passthrough := hiResDispatcher(d) != nil
+	remainders := make(map[string][2]int32)
+	var mutex sync.Mutex
+	return StageFunc("wheel", func(p *Packet) bool {
+		var hasV, hasH bool
+		var v, h int32
+		for _, e := range p.Events {
+			if e.Type != evdev.EventRelative {
+				continue
+			}
+			switch e.Code {
+			case uint16(RelativeWheelHiRes):
+				hasV, v = true, e.Value
+			case uint16(RelativeHWheelHiRes):
+				hasH, h = true, e.Value
+			}
+		}
+		if !hasV && !hasH {
+			return true
+		}
+		if passthrough {
+			if hasV {
+				p.SetRel(evdev.RelativeWheel, 0)
+			}
+			if hasH {
+				p.SetRel(evdev.RelativeHWheel, 0)
+			}
+			return true
+		}
+		devName := strings.ToLower(p.Device)
+		mutex.Lock()
+		r := remainders[devName]
+		if hasV {
+			r[0] += v
+			p.SetRel(evdev.RelativeWheel, r[0]/WheelHiResPerNotch)
+			r[0] %= WheelHiResPerNotch
+		}
+		if hasH {
+			r[1] += h
+			p.SetRel(evdev.RelativeHWheel, r[1]/WheelHiResPerNotch)
+			r[1] %= WheelHiResPerNotch
+		}
+		remainders[devName] = r
+		mutex.Unlock()
+		return true
+	})
+}
@@ -0,0 +1,304 @@
+package plugin
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"input2com/internal/clock"
+	"input2com/internal/input"
+	"input2com/internal/logger"
+	"input2com/internal/macros"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// conn 一个插件连接，连接断开时移除它注册的宏并结束正在运行的调用
+type conn struct {
+	host *Host
+	c    net.Conn
+
+	writeMutex sync.Mutex
+	enc        *json.Encoder
+
+	source string // 插件按下的按键记在这个输入源下，断开时松开
+
+	mutex   sync.Mutex
+	name    string
+	macros  []string
+	running map[uint64]chan struct{} // 调用 id -> macro.done 信号
+	closed  chan struct{}
+}
+
+var connIDs atomic.Uint64
+
+func newConn(h *Host, c net.Conn) *conn {
+	return &conn{
+		host:    h,
+		c:       c,
+		source:  fmt.Sprintf("plugin#%d", connIDs.Add(1)),
+		enc:     json.NewEncoder(c),
+		running: make(map[uint64]chan struct{}),
+		closed:  make(chan struct{}),
+	}
+}
+
+func (c *conn) send(msg *Message) error {
+	msg.JSONRPC = "2.0"
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	c.c.SetWriteDeadline(time.Now().Add(time.Second)) // 插件卡住时不阻塞宏
+	return c.enc.Encode(msg)
+}
+
+func (c *conn) notify(method string, params any) {
+	data, err := json.Marshal(params)
+	if err != nil {
+		return
+	}
+	if err := c.send(&Message{Method: method, Params: data}); err != nil {
+		logger.Logger.Warnf("发送 %s 到插件 %s 失败: %v", method, c.displayName(), err)
+	}
+}
+
+func (c *conn) displayName() string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.name == "" {
+		return "(未注册)"
+	}
+	return c.name
+}
+
+// serve 读取插件的请求直到连接断开
+func (c *conn) serve() {
+	defer c.close()
+	scanner := bufio.NewScanner(c.c)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var msg Message
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			c.send(&Message{Error: &Error{Code: ErrParse, Message: err.Error()}})
+			continue
+		}
+		if msg.Method == "" {
+			continue // 插件对通知的响应，忽略
+		}
+		result, err := c.handle(msg.Method, msg.Params)
+		if msg.ID == nil {
+			if err != nil {
+				logger.Logger.Warnf("插件 %s 调用 %s 失败: %v", c.displayName(), msg.Method, err)
+			}
+			continue
+		}
+		reply := &Message{ID: msg.ID, Result: result}
+		if err != nil {
+			rpcErr, ok := err.(*Error)
+			if !ok {
+				rpcErr = &Error{Code: ErrInternal, Message: err.Error()}
+			}
+			reply.Result, reply.Error = nil, rpcErr
+		} else if result == nil {
+			reply.Result = "ok"
+		}
+		c.send(reply)
+	}
+	if err := scanner.Err(); err != nil && !errors.Is(err, net.ErrClosed) {
+		logger.Logger.Warnf("插件 %s 连接出错: %v", c.displayName(), err)
+	}
+}
+
+func decodeParams[T any](raw json.RawMessage) (T, error) {
+	var params T
+	if len(raw) == 0 {
+		return params, nil
+	}
+	if err := json.Unmarshal(raw, &params); err != nil {
+		return params, &Error{Code: ErrInvalidParams, Message: err.Error()}
+	}
+	return params, nil
+}
+
+func parseKey(name string) (byte, bool, error) {
+	code, isMouse, ok := input.ParseKey(name)
+	if !ok {
+		return 0, false, &Error{Code: ErrInvalidParams, Message: fmt.Sprintf("unknown key or button %q", name)}
+	}
+	return code, isMouse, nil
+}
+
+func (c *conn) handle(method string, raw json.RawMessage) (any, error) {
+	mk := c.host.mk
+	switch method {
+	case "register":
+		params, err := decodeParams[RegisterParams](raw)
+		if err != nil {
+			return nil, err
+		}
+		return c.register(params)
+	case "key.press", "key.release", "key.tap":
+		params, err := decodeParams[KeyParams](raw)
+		if err != nil {
+			return nil, err
+		}
+		code, isMouse, err := parseKey(params.Key)
+		if err != nil {
+			return nil, err
+		}
+		if method != "key.release" {
+			c.press(code, isMouse, true)
+		}
+		if method == "key.tap" {
+			hold := params.Hold
+			if hold <= 0 {
+				hold = 10
+			}
+			clock.Sleep(mk.Clock, time.Duration(hold)*time.Millisecond)
+		}
+		if method != "key.press" {
+			c.press(code, isMouse, false)
+		}
+		if method == "key.tap" {
+			clock.Idle(mk.Clock) // 回去读取插件的消息，不再使用时钟
+		}
+		return nil, nil
+	case "mouse.move":
+		params, err := decodeParams[MoveParams](raw)
+		if err != nil {
+			return nil, err
+		}
+		return nil, mk.MouseMove(params.X, params.Y, params.Wheel)
+	case "type":
+		params, err := decodeParams[TypeParams](raw)
+		if err != nil {
+			return nil, err
+		}
+		return nil, mk.TypeText(params.Text, params.Layout, 0, c.closed)
+	case "macro.done":
+		params, err := decodeParams[MacroEvent](raw)
+		if err != nil {
+			return nil, err
+		}
+		c.mutex.Lock()
+		done, ok := c.running[params.ID]
+		delete(c.running, params.ID)
+		c.mutex.Unlock()
+		if ok {
+			close(done)
+		}
+		return nil, nil
+	}
+	return nil, &Error{Code: ErrMethodNotFound, Message: fmt.Sprintf("unknown method %q", method)}
+}
+
+// press 经过 MacroMouseKeyboard 按下或松开按键，ReleaseAll、切换方案和断开连接时会被松开
+func (c *conn) press(code byte, isMouse, down bool) {
+	c.host.mk.PressKey(c.source, code, isMouse, down)
+}
+
+// register 注册插件的宏，再次注册时替换上一次注册的宏。宏名不能与其他来源的宏重复
+func (c *conn) register(params RegisterParams) (any, error) {
+	if params.Name == "" {
+		return nil, &Error{Code: ErrInvalidParams, Message: "plugin name is required"}
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.name != "" && c.name != params.Name {
+		return nil, &Error{Code: ErrInvalidParams, Message: fmt.Sprintf("already registered as %q", c.name)}
+	}
+	if !c.host.claimName(params.Name, c) {
+		return nil, &Error{Code: ErrInvalidParams, Message: fmt.Sprintf("plugin %q is already connected", params.Name)}
+	}
+	registered := false
+	defer func() {
+		if !registered && c.name == "" {
+			c.host.releaseName(params.Name, c)
+		}
+	}()
+	m := make(map[string]macros.Macro, len(params.Macros))
+	for _, info := range params.Macros {
+		if info.Name == "" {
+			return nil, &Error{Code: ErrInvalidParams, Message: "macro name is required"}
+		}
+		if err := macros.ValidateParams(info.Params); err != nil {
+			return nil, &Error{Code: ErrInvalidParams, Message: fmt.Sprintf("%s: %v", info.Name, err)}
+		}
+		description := info.Description
+		if description == "" {
+			description = "插件 " + params.Name + " 的宏"
+		}
+		m[info.Name] = macros.Macro{Name: info.Name, Description: description, Params: info.Params, Run: c.runMacro(info.Name)}
+	}
+	if err := macros.SetPluginMacros(params.Name, m); err != nil {
+		return nil, &Error{Code: ErrInvalidParams, Message: err.Error()}
+	}
+	registered = true
+	c.name, c.macros = params.Name, make([]string, 0, len(m))
+	for name := range m {
+		c.macros = append(c.macros, name)
+	}
+	sort.Strings(c.macros)
+	logger.Logger.Infof("插件 %s 注册宏: %v", c.name, c.macros)
+	return map[string][]string{"macros": c.macros}, nil
+}
+
+// runMacro 按下时通知插件 macro.press，松开时通知 macro.release。
+// 插件发送 macro.done 或断开连接时调用结束
+func (c *conn) runMacro(name string) func(ctx context.Context, mk *macros.MacroMouseKeyboard) {
+	return func(ctx context.Context, mk *macros.MacroMouseKeyboard) {
+		inv, ok := macros.InvocationFromContext(ctx)
+		if !ok {
+			return
+		}
+		done := make(chan struct{})
+		c.mutex.Lock()
+		c.running[inv.ID] = done
+		c.mutex.Unlock()
+		defer func() {
+			c.mutex.Lock()
+			delete(c.running, inv.ID)
+			c.mutex.Unlock()
+		}()
+
+		args := make(map[string]string, len(inv.Args))
+		for arg := range inv.Args {
+			args[arg] = inv.Args.Text(arg)
+		}
+		c.notify("macro.press", MacroEvent{ID: inv.ID, Macro: name, Source: inv.Source, Trigger: inv.Trigger, Args: args})
+		select {
+		case <-ctx.Done():
+			c.notify("macro.release", MacroEvent{ID: inv.ID})
+		case <-done:
+		case <-c.closed:
+		}
+	}
+}
+
+// close 断开连接并移除注册的宏
+func (c *conn) close() {
+	c.c.Close()
+	c.mutex.Lock()
+	select {
+	case <-c.closed:
+		c.mutex.Unlock()
+		return
+	default:
+	}
+	close(c.closed)
+	name, names := c.name, c.macros
+	c.macros = nil
+	c.mutex.Unlock()
+
+	if name != "" {
+		macros.SetPluginMacros(name, nil)
+	}
+	c.host.mk.ReleaseDevice(c.source)
+	c.host.removeConn(c)
+	if len(names) > 0 {
+		logger.Logger.Warnf("插件 %s 断开连接，移除宏: %v", c.displayName(), names)
+	}
+}
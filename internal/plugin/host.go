@@ -0,0 +1,411 @@
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"input2com/internal/config"
+	"input2com/internal/logger"
+	"input2com/internal/macros"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+const (
+	minBackoff  = time.Second
+	maxBackoff  = 30 * time.Second
+	stableAfter = 30 * time.Second // 运行超过这个时间后退出，重启等待时间从头计算
+
+	restartDebounce = 500 * time.Millisecond // 编译时会多次写入，等写完再重启
+)
+
+// Host 插件宿主: 监听 Unix socket 接受插件连接，并启动和看护配置中的插件进程
+type Host struct {
+	mk       *macros.MacroMouseKeyboard
+	socket   string
+	listener net.Listener
+	stopChan chan struct{}
+	wg       sync.WaitGroup
+
+	mutex     sync.Mutex
+	conns     map[*conn]bool
+	processes []*process
+
+	nameMutex sync.Mutex // 可以在持有 conn.mutex 时获取
+	names     map[string]*conn
+}
+
+// ProcessStatus 插件进程状态
+type ProcessStatus struct {
+	Name      string    `json:"name"`
+	Command   []string  `json:"command"`
+	Running   bool      `json:"running"`
+	Pid       int       `json:"pid,omitempty"`
+	Restarts  int       `json:"restarts"`
+	LastError string    `json:"lastError,omitempty"`
+	Started   time.Time `json:"started,omitempty"`
+}
+
+// ConnStatus 已连接的插件
+type ConnStatus struct {
+	Name   string   `json:"name"`
+	Macros []string `json:"macros"`
+}
+
+// Status 插件进程和连接的状态，用于接口展示
+type Status struct {
+	Socket      string          `json:"socket"`
+	Processes   []ProcessStatus `json:"processes"`
+	Connections []ConnStatus    `json:"connections"`
+}
+
+type process struct {
+	cfg     config.PluginProcess
+	mutex   sync.Mutex
+	status  ProcessStatus
+	restart chan struct{} // 可执行文件更新后重启
+}
+
+func NewHost(mk *macros.MacroMouseKeyboard, cfg config.PluginConfig) *Host {
+	h := &Host{
+		mk:       mk,
+		socket:   config.GetPluginSocket(),
+		stopChan: make(chan struct{}),
+		conns:    make(map[*conn]bool),
+		names:    make(map[string]*conn),
+	}
+	for _, p := range cfg.Processes {
+		h.processes = append(h.processes, &process{
+			cfg:     p,
+			status:  ProcessStatus{Name: p.Name, Command: p.Command},
+			restart: make(chan struct{}, 1),
+		})
+	}
+	return h
+}
+
+// Start 开始监听 socket 并启动插件进程
+func (h *Host) Start() error {
+	listener, err := listen(h.socket)
+	if err != nil {
+		return err
+	}
+	h.listener = listener
+	logger.Logger.Infof("插件 socket: %s", h.socket)
+
+	h.wg.Add(1)
+	go h.accept()
+	for _, p := range h.processes {
+		if len(p.cfg.Command) == 0 {
+			logger.Logger.Errorf("插件 %s 没有配置 command", p.cfg.Name)
+			continue
+		}
+		h.wg.Add(1)
+		go h.supervise(p)
+	}
+	h.wg.Add(1)
+	go h.watch()
+	return nil
+}
+
+// listen 监听 socket。已有实例在监听时报错而不删除它的 socket，只清理上次异常退出留下的文件。
+// 插件可以控制键鼠，socket 先在只有当前用户能进入的临时目录中创建并设为 0600，再移动到 path，
+// 其他用户在任何时候都连接不上
+func listen(path string) (net.Listener, error) {
+	if c, err := net.DialTimeout("unix", path, time.Second); err == nil {
+		c.Close()
+		return nil, fmt.Errorf("%s 正在被其他进程监听，是否已经有 input2com 在运行?", path)
+	}
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s 已存在且不是 socket", path)
+		}
+		os.Remove(path)
+	}
+	dir, err := os.MkdirTemp(filepath.Dir(path), ".input2com-") // 权限为 0700
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	tmp := filepath.Join(dir, "sock")
+	listener, err := net.Listen("unix", tmp)
+	if err != nil {
+		return nil, err
+	}
+	listener.(*net.UnixListener).SetUnlinkOnClose(false) // 移动后的 socket 由 Stop 删除
+	if err = os.Chmod(tmp, 0600); err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+// Stop 停止插件进程并断开所有连接
+func (h *Host) Stop() {
+	close(h.stopChan)
+	if h.listener != nil {
+		h.listener.Close()
+	}
+	h.mutex.Lock()
+	conns := make([]*conn, 0, len(h.conns))
+	for c := range h.conns {
+		conns = append(conns, c)
+	}
+	h.mutex.Unlock()
+	for _, c := range conns {
+		c.close()
+	}
+	h.wg.Wait()
+	os.Remove(h.socket)
+}
+
+func (h *Host) accept() {
+	defer h.wg.Done()
+	for {
+		c, err := h.listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			logger.Logger.Errorf("接受插件连接失败: %v", err)
+			continue
+		}
+		pc := newConn(h, c)
+		h.mutex.Lock()
+		h.conns[pc] = true
+		h.mutex.Unlock()
+		go pc.serve()
+	}
+}
+
+func (h *Host) removeConn(c *conn) {
+	h.mutex.Lock()
+	delete(h.conns, c)
+	h.mutex.Unlock()
+	h.nameMutex.Lock()
+	defer h.nameMutex.Unlock()
+	for name, owner := range h.names {
+		if owner == c {
+			delete(h.names, name)
+		}
+	}
+}
+
+// claimName 为连接占用插件名，已被其他连接占用时返回 false。检查和占用是一步完成的
+func (h *Host) claimName(name string, c *conn) bool {
+	h.nameMutex.Lock()
+	defer h.nameMutex.Unlock()
+	if owner, ok := h.names[name]; ok && owner != c {
+		return false
+	}
+	h.names[name] = c
+	return true
+}
+
+// releaseName 注册失败时归还 claimName 占用的插件名
+func (h *Host) releaseName(name string, c *conn) {
+	h.nameMutex.Lock()
+	defer h.nameMutex.Unlock()
+	if h.names[name] == c {
+		delete(h.names, name)
+	}
+}
+
+// supervise 启动插件进程，退出后按指数退避重启，直到 Stop
+func (h *Host) supervise(p *process) {
+	defer h.wg.Done()
+	backoff := minBackoff
+	for {
+		started := time.Now()
+		restart, err := h.runProcess(p)
+		select {
+		case <-h.stopChan:
+			return
+		default:
+		}
+		if restart {
+			logger.Logger.Infof("插件 %s 已更新，重新启动", p.cfg.Name)
+			backoff = minBackoff
+			continue
+		}
+		if err != nil {
+			logger.Logger.Errorf("插件 %s 退出: %v", p.cfg.Name, err)
+		} else {
+			logger.Logger.Warnf("插件 %s 退出", p.cfg.Name)
+		}
+		if time.Since(started) > stableAfter {
+			backoff = minBackoff
+		}
+		select {
+		case <-h.stopChan:
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxBackoff)
+		p.mutex.Lock()
+		p.status.Restarts++
+		p.mutex.Unlock()
+	}
+}
+
+// runProcess 运行一次插件进程直到它退出，Stop 或需要重启时先发送 SIGTERM，超时后强制结束
+func (h *Host) runProcess(p *process) (restart bool, err error) {
+	cmd := exec.Command(p.cfg.Command[0], p.cfg.Command[1:]...)
+	cmd.Dir = p.cfg.Dir
+	cmd.Env = append(os.Environ(), SocketEnv+"="+h.socket, NameEnv+"="+p.cfg.Name)
+	cmd.Stdout = &logWriter{name: p.cfg.Name}
+	cmd.Stderr = cmd.Stdout
+	err = cmd.Start()
+	p.mutex.Lock()
+	if err != nil {
+		p.status.Running, p.status.Pid, p.status.LastError = false, 0, err.Error()
+		p.mutex.Unlock()
+		return false, err
+	}
+	p.status.Running, p.status.Pid, p.status.Started = true, cmd.Process.Pid, time.Now()
+	p.mutex.Unlock()
+	logger.Logger.Infof("启动插件 %s (pid %d)", p.cfg.Name, cmd.Process.Pid)
+
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+	terminate := func() {
+		cmd.Process.Signal(syscall.SIGTERM)
+		select {
+		case err = <-exited:
+		case <-time.After(2 * time.Second):
+			cmd.Process.Kill()
+			err = <-exited
+		}
+	}
+	requested := true // 由 Stop 或重启结束的进程不记录错误
+	select {
+	case err = <-exited:
+		requested = false
+	case <-h.stopChan:
+		terminate()
+	case <-p.restart:
+		terminate()
+		restart = true
+	}
+	p.mutex.Lock()
+	p.status.Running, p.status.Pid = false, 0
+	if err != nil && !requested {
+		p.status.LastError = err.Error()
+	}
+	p.mutex.Unlock()
+	return restart, err
+}
+
+// Status 返回插件进程和连接的状态
+func (h *Host) Status() Status {
+	status := Status{Socket: h.socket, Processes: []ProcessStatus{}, Connections: []ConnStatus{}}
+	for _, p := range h.processes {
+		p.mutex.Lock()
+		status.Processes = append(status.Processes, p.status)
+		p.mutex.Unlock()
+	}
+	h.mutex.Lock()
+	for c := range h.conns {
+		c.mutex.Lock()
+		status.Connections = append(status.Connections, ConnStatus{Name: c.name, Macros: append([]string{}, c.macros...)})
+		c.mutex.Unlock()
+	}
+	h.mutex.Unlock()
+	sort.Slice(status.Connections, func(i, j int) bool { return status.Connections[i].Name < status.Connections[j].Name })
+	return status
+}
+
+// logWriter 将插件的输出按行写入日志
+type logWriter struct {
+	name string
+}
+
+func (w *logWriter) Write(p []byte) (int, error) {
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		logger.Logger.Infof("[插件 %s] %s", w.name, line)
+	}
+	return len(p), nil
+}
+
+// watchedFiles 插件命令中存在的文件(程序本身或脚本参数)，文件更新后重启插件
+func (p *process) watchedFiles() []string {
+	var files []string
+	for _, arg := range p.cfg.Command {
+		file := arg
+		if !filepath.IsAbs(file) && p.cfg.Dir != "" {
+			file = filepath.Join(p.cfg.Dir, file)
+		}
+		if info, err := os.Stat(file); err == nil && info.Mode().IsRegular() {
+			if abs, err := filepath.Abs(file); err == nil {
+				files = append(files, abs)
+			}
+		}
+	}
+	return files
+}
+
+// watch 监听插件文件，更新(如重新编译)后重启对应的插件进程
+func (h *Host) watch() {
+	defer h.wg.Done()
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		logger.Logger.Errorf("无法监听插件文件: %v", err)
+		return
+	}
+	defer watcher.Close()
+	owners := make(map[string][]*process)
+	for _, p := range h.processes {
+		for _, file := range p.watchedFiles() {
+			if len(owners[file]) == 0 {
+				if err := watcher.Add(filepath.Dir(file)); err != nil {
+					logger.Logger.Warnf("无法监听 %s: %v", file, err)
+				}
+			}
+			owners[file] = append(owners[file], p)
+		}
+	}
+	pending := make(map[*process]bool)
+	var timer <-chan time.Time
+	for {
+		select {
+		case <-h.stopChan:
+			return
+		case ev, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			if !ev.Has(fsnotify.Write) && !ev.Has(fsnotify.Create) {
+				continue
+			}
+			for _, p := range owners[filepath.Clean(ev.Name)] {
+				pending[p] = true
+				timer = time.After(restartDebounce)
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			logger.Logger.Errorf("监听插件文件出错: %v", err)
+		case <-timer:
+			for p := range pending {
+				select {
+				case p.restart <- struct{}{}:
+				default:
+				}
+			}
+			pending = make(map[*process]bool)
+			timer = nil
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package plugin
+
+import (
+	"fmt"
+	"input2com/internal/clock"
+	"input2com/internal/config"
+	"input2com/internal/input"
+	"input2com/internal/macros"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestListen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "input2com.sock")
+
+	// 上次异常退出留下的 socket 文件
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	listener, err := listen(path)
+	if err != nil {
+		t.Fatalf("stale socket not replaced: %v", err)
+	}
+	defer listener.Close()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm&0077 != 0 {
+		t.Errorf("socket permissions = %v, want no group/other access", perm)
+	}
+
+	if _, err := listen(path); err == nil {
+		t.Fatal("listen took over a live socket")
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("live socket was removed: %v", err)
+	}
+}
+
+// recordCtrl 记录发给目标端的按键
+type recordCtrl struct {
+	mutex sync.Mutex
+	calls []string
+}
+
+func (r *recordCtrl) record(call string, code byte) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.calls = append(r.calls, fmt.Sprintf("%s %#x", call, code))
+	return nil
+}
+
+func (r *recordCtrl) MouseBtnDown(keyCode byte) error      { return r.record("mdown", keyCode) }
+func (r *recordCtrl) MouseBtnUp(keyCode byte) error        { return r.record("mup", keyCode) }
+func (r *recordCtrl) MouseMove(dx, dy, wheel int32) error  { return nil }
+func (r *recordCtrl) IsMouseBtnPressed(keyCode byte) bool  { return false }
+func (r *recordCtrl) KeyDown(keyCode byte) error           { return r.record("kdown", keyCode) }
+func (r *recordCtrl) KeyUp(keyCode byte) error             { return r.record("kup", keyCode) }
+func (r *recordCtrl) LockMouse(Button int, lock int) error { return nil }
+func (r *recordCtrl) Click(i int) error                    { return nil }
+func (r *recordCtrl) MouseBtnMask() byte                   { return input.HIDMouseBtns }
+
+func newTestHost(ctrl macros.MouseCtrl) *Host {
+	return NewHost(macros.NewMacroMouseKeyboardWithClock(ctrl, clock.Real), config.PluginConfig{})
+}
+
+func newTestConn(h *Host) *conn {
+	local, remote := net.Pipe()
+	go io.Copy(io.Discard, remote)
+	c := newConn(h, local)
+	h.mutex.Lock()
+	h.conns[c] = true
+	h.mutex.Unlock()
+	return c
+}
+
+func TestRegisterNameOnce(t *testing.T) {
+	h := newTestHost(&recordCtrl{})
+	var wg sync.WaitGroup
+	var registered atomic.Int32
+	for i := 0; i < 8; i++ {
+		c := newTestConn(h)
+		defer c.close()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			params := RegisterParams{Name: "test_dup", Macros: []MacroInfo{{Name: "test_dup_macro"}}}
+			if _, err := c.register(params); err == nil {
+				registered.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := registered.Load(); n != 1 {
+		t.Errorf("%d connections registered the same name, want 1", n)
+	}
+}
+
+func TestPluginKeysReleased(t *testing.T) {
+	ctrl := &recordCtrl{}
+	h := newTestHost(ctrl)
+	c := newTestConn(h)
+	c.press(0x04, false, true)
+	c.press(0x01, true, true)
+	c.close() // 断开时松开插件按着的按键
+	ctrl.mutex.Lock()
+	defer ctrl.mutex.Unlock()
+	sort.Strings(ctrl.calls[2:]) // 松开的顺序不固定
+	want := []string{"kdown 0x4", "mdown 0x1", "kup 0x4", "mup 0x1"}
+	if !reflect.DeepEqual(ctrl.calls, want) {
+		t.Errorf("got %q, want %q", ctrl.calls, want)
+	}
+}
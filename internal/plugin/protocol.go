@@ -0,0 +1,91 @@
+package plugin
+
+import (
+	"encoding/json"
+	"input2com/internal/macros"
+)
+
+// 插件协议: Unix socket 上的 JSON-RPC 2.0，每条消息一行 JSON，双方都可以发送请求。
+//
+// 插件 -> input2com (请求，带 id 时会收到响应):
+//
+//	register    {"name": "example", "macros": [{"name": "autoclick", "description": "...", "params": [...]}]}
+//	key.press   {"key": "left"}              按下按键或鼠标键，键名同配置文件
+//	key.release {"key": "left"}
+//	key.tap     {"key": "a", "hold": 10}     hold 为按住的毫秒数
+//	mouse.move  {"x": 10, "y": 0, "wheel": 0}
+//	type        {"text": "gg", "layout": "us"}
+//	macro.done  {"id": 3}                    宏提前执行完毕，结束这次调用
+//
+// input2com -> 插件 (通知，不需要响应):
+//
+//	macro.press   {"id": 3, "macro": "autoclick", "source": "mouse0", "trigger": "mouse:0x10", "args": {"interval": "50ms"}}
+//	macro.release {"id": 3}
+
+const (
+	SocketEnv = "INPUT2COM_PLUGIN_SOCKET" // 启动插件进程时传入 socket 路径
+	NameEnv   = "INPUT2COM_PLUGIN_NAME"   // 启动插件进程时传入配置中的插件名
+)
+
+// JSON-RPC 错误码
+const (
+	ErrParse          = -32700
+	ErrMethodNotFound = -32601
+	ErrInvalidParams  = -32602
+	ErrInternal       = -32603
+)
+
+// Message 请求、通知和响应共用的消息格式
+type Message struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id,omitempty"` // 通知没有 id
+	Method  string           `json:"method,omitempty"`
+	Params  json.RawMessage  `json:"params,omitempty"`
+	Result  any              `json:"result,omitempty"`
+	Error   *Error           `json:"error,omitempty"`
+}
+
+type Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+type MacroInfo struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description"`
+	Params      []macros.Param `json:"params,omitempty"` // 同宏文件中的 params，绑定中给出的参数随 macro.press 发送
+}
+
+type RegisterParams struct {
+	Name   string      `json:"name"`
+	Macros []MacroInfo `json:"macros"`
+}
+
+type KeyParams struct {
+	Key  string `json:"key"`
+	Hold int    `json:"hold,omitempty"`
+}
+
+type MoveParams struct {
+	X     int32 `json:"x"`
+	Y     int32 `json:"y"`
+	Wheel int32 `json:"wheel"`
+}
+
+type TypeParams struct {
+	Text   string `json:"text"`
+	Layout string `json:"layout,omitempty"`
+}
+
+// MacroEvent macro.press / macro.release / macro.done 的参数
+type MacroEvent struct {
+	ID      uint64            `json:"id"`
+	Macro   string            `json:"macro,omitempty"`
+	Source  string            `json:"source,omitempty"`
+	Trigger string            `json:"trigger,omitempty"`
+	Args    map[string]string `json:"args,omitempty"` // 参数值，写法同绑定，如 50ms、left
+}
@@ -0,0 +1,233 @@
+package recorder
+
+import (
+	"errors"
+	"fmt"
+	"input2com/internal/input"
+	"input2com/internal/logger"
+	"input2com/internal/macros"
+	"input2com/internal/pipeline"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kenshaw/evdev"
+)
+
+// DefaultStopKey 默认的停止录制热键
+const DefaultStopKey = "scrolllock"
+
+// Options 录制选项
+type Options struct {
+	Name        string        `json:"name"`
+	Description string        `json:"description"`
+	Device      string        `json:"device"`      // 只录制该设备，空表示所有设备
+	Quantize    time.Duration `json:"quantize"`    // 等待时间按此粒度取整，0 表示不取整
+	DropMotion  bool          `json:"drop_motion"` // 不录制鼠标移动
+	StopKey     string        `json:"stop_key"`    // 停止录制的热键，该键不会被录制和转发
+}
+
+// Status 录制状态
+type Status struct {
+	Recording bool    `json:"recording"`
+	Options   Options `json:"options"`
+	Steps     int     `json:"steps"`
+	Last      string  `json:"last"`  // 上一次保存的宏名
+	Error     string  `json:"error"` // 上一次保存失败的原因
+}
+
+type session struct {
+	opts      Options
+	stopCode  byte
+	stopMouse bool // 停止热键是否为鼠标按键
+	steps     []macros.Step
+	last      time.Time
+}
+
+var (
+	mutex   sync.Mutex
+	current *session
+	status  Status
+	swallow *pipeline.Event // 录制结束后需要吞掉的停止热键松开事件
+)
+
+var (
+	ErrRecording    = errors.New("already recording")
+	ErrNotRecording = errors.New("not recording")
+)
+
+// Start 开始录制，事件由 Stage 从输入管道中获取
+func Start(opts Options) error {
+	if opts.Name == "" || strings.ContainsAny(opts.Name, `/\`) {
+		return fmt.Errorf("invalid macro name %q", opts.Name)
+	}
+	if opts.StopKey == "" {
+		opts.StopKey = DefaultStopKey
+	}
+	code, isMouse, ok := input.ParseKey(opts.StopKey)
+	if !ok {
+		return fmt.Errorf("unknown stop key %q", opts.StopKey)
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	if current != nil {
+		return ErrRecording
+	}
+	current = &session{opts: opts, stopCode: code, stopMouse: isMouse}
+	status = Status{Recording: true, Options: opts}
+	logger.Logger.Infof("开始录制宏 %s，按 %s 停止", opts.Name, opts.StopKey)
+	return nil
+}
+
+// Stop 停止录制，保存并注册宏
+func Stop() (*macros.MacroDefinition, error) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	return finish()
+}
+
+// Get 返回录制状态
+func Get() Status {
+	mutex.Lock()
+	defer mutex.Unlock()
+	if current != nil {
+		status.Steps = len(current.steps)
+	}
+	return status
+}
+
+// finish 结束当前录制，调用时需持有锁
+func finish() (*macros.MacroDefinition, error) {
+	if current == nil {
+		return nil, ErrNotRecording
+	}
+	s := current
+	current = nil
+	def := &macros.MacroDefinition{
+		Name:        s.opts.Name,
+		Description: s.opts.Description,
+		Steps:       s.steps,
+	}
+	status.Recording = false
+	status.Steps = len(s.steps)
+	status.Last = def.Name
+	status.Error = ""
+	if err := macros.SaveMacroFile(def); err != nil {
+		status.Error = err.Error()
+		logger.Logger.Errorf("保存宏 %s 失败: %v", def.Name, err)
+		return nil, err
+	}
+	macros.RegisterMacro(def)
+	logger.Logger.Infof("录制完成，宏 %s 共 %d 步，已保存到 %s", def.Name, len(def.Steps), def.File)
+	return def, nil
+}
+
+// add 追加一个步骤，在前面插入与上一个步骤的时间间隔
+func (s *session) add(at time.Time, step macros.Step) {
+	if !s.last.IsZero() {
+		wait := at.Sub(s.last)
+		if s.opts.Quantize > 0 {
+			wait = wait.Round(s.opts.Quantize)
+		}
+		if wait > 0 {
+			s.steps = append(s.steps, macros.Step{Kind: macros.StepWait, Duration: wait})
+			s.last = s.last.Add(wait) // 取整误差留到下一次，避免累计漂移
+		}
+	} else {
+		s.last = at
+	}
+	// 中间没有等待的连续移动合并为一步
+	if n := len(s.steps); n > 0 && step.Kind == macros.StepMove && s.steps[n-1].Kind == macros.StepMove {
+		s.steps[n-1].X += step.X
+		s.steps[n-1].Y += step.Y
+		return
+	}
+	s.steps = append(s.steps, step)
+}
+
+// isStopKey 判断事件是否为停止热键
+func (s *session) isStopKey(e pipeline.Event) bool {
+	if e.Type != evdev.EventKey {
+		return false
+	}
+	if btn, isMouse := input.EvdevMouseBtns[e.Code]; isMouse {
+		return s.stopMouse && btn == s.stopCode
+	}
+	return !s.stopMouse && input.Linux2hid[e.Code] == s.stopCode
+}
+
+// record 录制一个包，返回 true 表示按下了停止热键
+func (s *session) record(p *pipeline.Packet) bool {
+	at := p.Time
+	if at.IsZero() {
+		at = time.Now()
+	}
+	for _, e := range p.Events {
+		if s.isStopKey(e) {
+			if e.Value == 1 {
+				return true
+			}
+			continue
+		}
+		if e.Type != evdev.EventKey || e.Value == 2 {
+			continue
+		}
+		var code byte
+		btn, isMouse := input.EvdevMouseBtns[e.Code]
+		if isMouse {
+			if btn >= input.MouseBtnExtBase {
+				continue // 没有对应位的扩展按键无法输出，其余按键回放时由控制器决定
+			}
+			code = btn
+		} else if code = input.Linux2hid[e.Code]; code == 0 {
+			continue
+		}
+		kind := macros.StepRelease
+		if e.Value == 1 {
+			kind = macros.StepPress
+		}
+		s.add(at, macros.Step{Kind: kind, Code: code, IsMouse: isMouse})
+	}
+	if !s.opts.DropMotion {
+		if x, y := p.Rel(evdev.RelativeX), p.Rel(evdev.RelativeY); x != 0 || y != 0 {
+			s.add(at, macros.Step{Kind: macros.StepMove, X: x, Y: y})
+		}
+	}
+	if wheel := p.Rel(evdev.RelativeWheel); wheel != 0 {
+		s.add(at, macros.Step{Kind: macros.StepWheel, Wheel: wheel})
+	}
+	return false
+}
+
+// Stage 录制阶段，放在宏分发之前，录制的是重映射和灵敏度处理后的事件
+func Stage() pipeline.Stage {
+	return pipeline.StageFunc("record", func(p *pipeline.Packet) bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if swallow != nil {
+			for i, e := range p.Events {
+				if e.Type == swallow.Type && e.Code == swallow.Code && e.Value == 0 {
+					p.Events = append(p.Events[:i], p.Events[i+1:]...)
+					swallow = nil
+					break
+				}
+			}
+		}
+		if current == nil || (current.opts.Device != "" && !strings.EqualFold(current.opts.Device, p.Device)) {
+			return true
+		}
+		if !current.record(p) {
+			return true
+		}
+		// 停止热键的按下和松开都不转发
+		for i, e := range p.Events {
+			if current.isStopKey(e) && e.Value == 1 {
+				swallow = &e
+				p.Events = append(p.Events[:i], p.Events[i+1:]...)
+				break
+			}
+		}
+		finish()
+		return true
+	})
+}
@@ -0,0 +1,187 @@
+package sensitivity
+
+import (
+	"fmt"
+	"input2com/internal/config"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	CurveLinear = "linear" // 无加速
+	CurvePower  = "power"  // 增益 = 速度^(power-1)
+	CurveTable  = "table"  // 按速度在表中线性插值得到增益
+)
+
+var (
+	defaultProfile = config.SensitivityProfile{X: Ratio(1), Y: Ratio(1), Curve: CurveLinear}
+	devProfiles    = make(map[string]config.SensitivityProfile)
+	residuals      = make(map[string][2]float64) // 每个设备X/Y的小数累积
+	mutex          sync.Mutex
+)
+
+// Load 从配置加载灵敏度
+func Load(cfg config.SensitivityConfig) error {
+	def, devs, err := parse(cfg)
+	if err != nil {
+		return err
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	defaultProfile = def
+	devProfiles = devs
+	residuals = make(map[string][2]float64)
+	return nil
+}
+
+// Check 检查配置但不加载
+func Check(cfg config.SensitivityConfig) error {
+	_, _, err := parse(cfg)
+	return err
+}
+
+func parse(cfg config.SensitivityConfig) (config.SensitivityProfile, map[string]config.SensitivityProfile, error) {
+	def := cfg.Default
+	if err := Validate(&def); err != nil {
+		return def, nil, fmt.Errorf("default: %w", err)
+	}
+	devs := make(map[string]config.SensitivityProfile)
+	for name, p := range cfg.Devices {
+		if err := Validate(&p); err != nil {
+			return def, nil, fmt.Errorf("%s: %w", name, err)
+		}
+		devs[strings.ToLower(name)] = p
+	}
+	return def, devs, nil
+}
+
+// Ratio 返回倍率的指针，用于填写 SensitivityProfile
+func Ratio(v float64) *float64 {
+	return &v
+}
+
+// Validate 检查并补全配置，未填写的倍率视为1，填写0时该轴不移动
+func Validate(p *config.SensitivityProfile) error {
+	if p.X == nil {
+		p.X = Ratio(1)
+	}
+	if p.Y == nil {
+		p.Y = Ratio(1)
+	}
+	switch p.Curve {
+	case "", CurveLinear:
+		p.Curve = CurveLinear
+	case CurvePower:
+		if p.Power <= 0 {
+			return fmt.Errorf("power curve requires power > 0")
+		}
+	case CurveTable:
+		if len(p.Table) == 0 {
+			return fmt.Errorf("table curve requires at least one point")
+		}
+		p.Table = append([]config.SensitivityPoint(nil), p.Table...) // 不修改调用方的表
+		sort.Slice(p.Table, func(i, j int) bool { return p.Table[i].Speed < p.Table[j].Speed })
+	default:
+		return fmt.Errorf("unknown curve %q", p.Curve)
+	}
+	return nil
+}
+
+// ParseTable 解析 "speed:gain,speed:gain" 形式的曲线表，用于HTTP接口
+func ParseTable(s string) ([]config.SensitivityPoint, error) {
+	points := make([]config.SensitivityPoint, 0)
+	for _, item := range strings.Split(s, ",") {
+		parts := strings.Split(strings.TrimSpace(item), ":")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid table point %q", item)
+		}
+		speed, err := strconv.ParseFloat(parts[0], 64)
+		if err != nil {
+			return nil, err
+		}
+		gain, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil {
+			return nil, err
+		}
+		points = append(points, config.SensitivityPoint{Speed: speed, Gain: gain})
+	}
+	return points, nil
+}
+
+func gain(p config.SensitivityProfile, speed float64) float64 {
+	switch p.Curve {
+	case CurvePower:
+		if speed < 1 {
+			return 1
+		}
+		return math.Pow(speed, p.Power-1)
+	case CurveTable:
+		t := p.Table
+		if speed <= t[0].Speed {
+			return t[0].Gain
+		}
+		for i := 1; i < len(t); i++ {
+			if speed <= t[i].Speed {
+				ratio := (speed - t[i-1].Speed) / (t[i].Speed - t[i-1].Speed)
+				return t[i-1].Gain + (t[i].Gain-t[i-1].Gain)*ratio
+			}
+		}
+		return t[len(t)-1].Gain
+	}
+	return 1
+}
+
+// Apply 按设备配置缩放相对移动，小数部分累积到下一次报告
+func Apply(devName string, dx, dy int32) (int32, int32) {
+	if dx == 0 && dy == 0 {
+		return 0, 0
+	}
+	devName = strings.ToLower(devName)
+	mutex.Lock()
+	defer mutex.Unlock()
+	p, ok := devProfiles[devName]
+	if !ok {
+		p = defaultProfile
+	}
+	x, y := *p.X, *p.Y
+	if p.Curve == CurveLinear && x == 1 && y == 1 {
+		return dx, dy
+	}
+	g := gain(p, math.Hypot(float64(dx), float64(dy)))
+	r := residuals[devName]
+	fx := float64(dx)*x*g + r[0]
+	fy := float64(dy)*y*g + r[1]
+	outX, outY := int32(fx), int32(fy)
+	residuals[devName] = [2]float64{fx - float64(outX), fy - float64(outY)}
+	return outX, outY
+}
+
+// Set 修改设备的灵敏度，devName 为空时修改默认值
+func Set(devName string, p config.SensitivityProfile) error {
+	if err := Validate(&p); err != nil {
+		return err
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	if devName == "" {
+		defaultProfile = p
+	} else {
+		devProfiles[strings.ToLower(devName)] = p
+	}
+	residuals = make(map[string][2]float64)
+	return nil
+}
+
+// Get 返回当前的灵敏度配置
+func Get() config.SensitivityConfig {
+	mutex.Lock()
+	defer mutex.Unlock()
+	devs := make(map[string]config.SensitivityProfile, len(devProfiles))
+	for name, p := range devProfiles {
+		devs[name] = p
+	}
+	return config.SensitivityConfig{Default: defaultProfile, Devices: devs}
+}
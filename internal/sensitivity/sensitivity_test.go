@@ -0,0 +1,52 @@
+package sensitivity
+
+import (
+	"input2com/internal/config"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		in   config.SensitivityProfile
+		x, y float64
+	}{
+		{"missing axes default to 1", config.SensitivityProfile{}, 1, 1},
+		{"zero disables an axis", config.SensitivityProfile{X: Ratio(0), Y: Ratio(2)}, 0, 2},
+		{"only y given", config.SensitivityProfile{Y: Ratio(0.5)}, 1, 0.5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := tt.in
+			if err := Validate(&p); err != nil {
+				t.Fatal(err)
+			}
+			if *p.X != tt.x || *p.Y != tt.y || p.Curve != CurveLinear {
+				t.Errorf("got x=%v y=%v curve=%q, want x=%v y=%v linear", *p.X, *p.Y, p.Curve, tt.x, tt.y)
+			}
+		})
+	}
+}
+
+func TestValidateSortsTableCopy(t *testing.T) {
+	table := []config.SensitivityPoint{{Speed: 20, Gain: 2}, {Speed: 0, Gain: 1}}
+	p := config.SensitivityProfile{Curve: CurveTable, Table: table}
+	if err := Validate(&p); err != nil {
+		t.Fatal(err)
+	}
+	if p.Table[0].Speed != 0 || p.Table[1].Speed != 20 {
+		t.Errorf("table not sorted: %v", p.Table)
+	}
+	if table[0].Speed != 20 {
+		t.Errorf("caller's table was modified: %v", table)
+	}
+}
+
+func TestApplyDisabledAxis(t *testing.T) {
+	if err := Set("test-disabled-axis", config.SensitivityProfile{X: Ratio(0)}); err != nil {
+		t.Fatal(err)
+	}
+	if dx, dy := Apply("test-disabled-axis", 5, 7); dx != 0 || dy != 7 {
+		t.Errorf("Apply = %d, %d, want 0, 7", dx, dy)
+	}
+}
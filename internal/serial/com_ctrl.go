@@ -41,9 +41,7 @@ type ComMouseKeyboard struct {
 	keyBytes        []byte
 	mu              sync.Mutex
 	limiter         *rate.Limiter
-	speed           float64
-	residualDx      float64 // 存储dx的小数累积
-	aiming          int32   // 原子标志位：0-非瞄准状态，1-瞄准状态
+	aiming          int32 // 原子标志位：0-非瞄准状态，1-瞄准状态
 
 	// 新增：读取相关字段
 	recvChan   chan []byte   // 接收数据的通道
@@ -67,9 +65,6 @@ func (mk *ComMouseKeyboard) SetAiming(aiming bool) {
 func (mk *ComMouseKeyboard) IsAiming() bool {
 	return atomic.LoadInt32(&mk.aiming) == 1
 }
-func (mk *ComMouseKeyboard) SetSpeed(speed float64) {
-	mk.speed = speed
-}
 func (mk *ComMouseKeyboard) Write(p []byte) (n int, err error) {
 	// 等待限流器许可
 	if err = mk.limiter.Wait(context.TODO()); err != nil {
@@ -182,7 +177,6 @@ func NewComMouseKeyboard(portName string, baudRate int) *ComMouseKeyboard {
 		mouseButtonByte: 0x00,
 		keyBytes:        []byte{0x57, 0xAB, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
 		limiter:         rate.NewLimiter(rate.Every(time.Millisecond), 1),
-		speed:           1,
 	}
 }
 
@@ -257,22 +251,11 @@ func int16ToBytes(value int16) [2]byte {
 }
 
 // 原有的小范围移动方法（保持兼容性）
+// 灵敏度缩放统一在 sensitivity 包中处理，这里不再缩放
 func (mk *ComMouseKeyboard) MouseMove(dx, dy, wheel int32) error {
 	mk.mu.Lock()
 	defer mk.mu.Unlock()
-	// 加上之前累积的小数部分
-	totalDx := float64(dx)
-
-	// 根据速度缩放
-	scaledDx := totalDx*mk.speed + mk.residualDx
-
-	// 取整数部分作为本次移动
-	moveDx := int32(scaledDx)
-
-	// 保存小数部分用于下次补偿
-	mk.residualDx = scaledDx - float64(moveDx)
-
-	_, err := mk.Write([]byte{0x57, 0xAB, 0x02, mk.mouseButtonByte, intToByte(moveDx), intToByte(dy), intToByte(wheel)})
+	_, err := mk.Write([]byte{0x57, 0xAB, 0x02, mk.mouseButtonByte, intToByte(dx), intToByte(dy), intToByte(wheel)})
 	if err != nil {
 		return err
 	}
@@ -301,6 +284,11 @@ func (mk *ComMouseKeyboard) MouseBtnClick(keyCode byte) error {
 func (mk *ComMouseKeyboard) IsMouseBtnPressed(keyCode byte) bool {
 	return mk.mouseButtonByte&keyCode != 0
 }
+
+// MouseBtnMask CH9329 的鼠标报告只有左、右、中三个按键位，其余位必须为 0
+func (mk *ComMouseKeyboard) MouseBtnMask() byte {
+	return input.MouseBtnLeft | input.MouseBtnRight | input.MouseBtnMiddle
+}
 func (mk *ComMouseKeyboard) MouseBtnDown(keyCode byte) error {
 	mk.mu.Lock()
 	defer mk.mu.Unlock()
@@ -333,6 +321,9 @@ func (mk *ComMouseKeyboard) KeyDown(keyCode byte) error {
 			if i == 6 {
 				return nil // No space to add new key, ignore
 			}
+			if mk.keyBytes[i+5] == keyCode {
+				break // 已经按下，重复按下只重发报告
+			}
 			if mk.keyBytes[i+5] == 0x00 {
 				mk.keyBytes[i+5] = keyCode
 				break
@@ -368,3 +359,18 @@ func (mk *ComMouseKeyboard) KeyUp(keyCode byte) error {
 	}
 	return nil
 }
+
+// SendNeutral 发送按键全部释放的鼠标和键盘报告
+func (mk *ComMouseKeyboard) SendNeutral() error {
+	mk.mu.Lock()
+	defer mk.mu.Unlock()
+	mk.mouseButtonByte = 0x00
+	for i := 3; i < len(mk.keyBytes); i++ {
+		mk.keyBytes[i] = 0x00
+	}
+	if _, err := mk.Write([]byte{0x57, 0xAB, 0x02, 0x00, 0x00, 0x00, 0x00}); err != nil {
+		return err
+	}
+	_, err := mk.Write(mk.keyBytes)
+	return err
+}
@@ -144,6 +144,17 @@ func (m *MakcuHandle) MouseBtnUp(keyCode byte) error {
 	}
 	return nil
 }
+
+// SendNeutral 释放所有鼠标按键并解除锁定
+func (m *MakcuHandle) SendNeutral() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.mouseButtonByte = 0x00
+	cmd := "km.left(0)\r km.right(0)\r km.middle(0)\r km.side1(0)\r km.side2(0)\r km.lock_mx(0)\r km.lock_my(0)\r"
+	_, err := m.Write([]byte(cmd))
+	return err
+}
+
 func (m *MakcuHandle) LeftClick() error {
 	if m == nil {
 		return fmt.Errorf("LeftClick: MakcuHandle is nil (no device connected)")
@@ -403,6 +414,15 @@ func (m *MakcuHandle) MouseMove(dx, dy, wheel int32) error {
 	return nil
 }
 
+// MouseHWheel 横向滚动，整格
+func (m *MakcuHandle) MouseHWheel(steps int32) error {
+	_, err := m.Write([]byte(fmt.Sprintf("km.pan(%d)\r", steps)))
+	if err != nil {
+		logger.Logger.Infof("Failed to pan mouse: Write Error: %v", err)
+	}
+	return err
+}
+
 // use a curve with the built in curve functionality from MAKCU... i THINK this is only on fw v3+ ??? idk don't care to fact check it rn either :)
 // "It is common sense that the higher the number of the third parameter, the smoother the curve will be fitted" - from MAKCU/km box docs
 func (m *MakcuHandle) MoveMouseWithCurve(x, y int, params ...int) error {
@@ -678,6 +698,15 @@ func (m *MakcuHandle) GetButtonStates() map[string]bool {
 func (m *MakcuHandle) IsMouseBtnPressed(keyCode byte) bool {
 	return (m.currentButtonMask & keyCode) != 0
 }
+
+// MouseBtnMask makcu 只有 km.left/right/middle/side1/side2 五个按键命令
+func (m *MakcuHandle) MouseBtnMask() byte {
+	var mask byte
+	for btn := range input.MouseKeyDown {
+		mask |= btn
+	}
+	return mask
+}
 func (m *MakcuHandle) KeyDown(keyCode byte) error {
 	return nil
 }
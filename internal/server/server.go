@@ -4,12 +4,20 @@ import (
 	"embed"
 	"fmt"
 	"input2com/internal/config"
+	"input2com/internal/device"
 	"input2com/internal/input"
+	"input2com/internal/latency"
 	"input2com/internal/logger"
 	"input2com/internal/macros"
+	"input2com/internal/plugin"
+	"input2com/internal/recorder"
+	"input2com/internal/sensitivity"
 	"io/fs"
 	"net/http"
 	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -17,6 +25,20 @@ import (
 //go:embed server/build
 var StaticFS embed.FS
 
+// macroKB 需要直接操作目标端的接口使用，由 SetMacroKeyboard 设置
+var macroKB atomic.Pointer[macros.MacroMouseKeyboard]
+
+// pluginHost 插件宿主，由 SetPluginHost 设置
+var pluginHost atomic.Pointer[plugin.Host]
+
+func SetMacroKeyboard(mk *macros.MacroMouseKeyboard) {
+	macroKB.Store(mk)
+}
+
+func SetPluginHost(host *plugin.Host) {
+	pluginHost.Store(host)
+}
+
 func Serve() {
 	gin.SetMode(gin.ReleaseMode)
 	router := gin.Default()
@@ -28,6 +50,27 @@ func Serve() {
 		api.GET("/get/keyboard", getKeyboardConfig)
 		api.GET("/set/mouse", setMouseConfig)
 		api.GET("/set/keyboard", setKeyboardConfig)
+		api.GET("/get/sensitivity", getSensitivity)
+		api.GET("/set/sensitivity", setSensitivity)
+		api.GET("/get/devices", getDevices)
+		api.GET("/get/latency", getLatency)
+		api.GET("/reset/latency", resetLatency)
+		api.GET("/record/start", startRecord)
+		api.GET("/record/stop", stopRecord)
+		api.GET("/get/record", getRecord)
+		api.GET("/type", typeText)
+		api.GET("/get/layers", getLayers)
+		api.GET("/macros/running", getRunningMacros)
+		api.GET("/macros/cancel", cancelMacro)
+		api.GET("/set/layer", setLayer)
+		api.GET("/get/layouts", getLayouts)
+		api.GET("/get/scripts", getScripts)
+		api.GET("/scripts/reload", reloadScripts)
+		api.GET("/get/plugins", getPlugins)
+		api.GET("/get/macros/status", getMacroStatus)
+		api.GET("/macros/reload", reloadMacros)
+		api.GET("/get/profiles", getProfiles)
+		api.GET("/set/profile", setProfile)
 	}
 	// 2️⃣ 再注册静态文件路由（兜底）
 	subFS, err := fs.Sub(StaticFS, "server/build")
@@ -41,7 +84,7 @@ func Serve() {
 func getMacros(c *gin.Context) {
 	macros.KeyboarddictMutex.RLock()
 	defer macros.KeyboarddictMutex.RUnlock()
-	c.JSON(http.StatusOK, macros.Macros)
+	c.JSON(http.StatusOK, macros.MacrosWithTriggers())
 }
 
 func getMouseConfig(c *gin.Context) {
@@ -60,7 +103,7 @@ func setMouseConfig(c *gin.Context) {
 	macros.MousedictMutex.Lock()
 	defer macros.MousedictMutex.Unlock()
 	key := c.Query("key")
-	devName := c.Query("devName")
+	devName := strings.ToLower(c.Query("devName"))
 	value := c.Query("value")
 
 	if key == "CLEAR_ALL" {
@@ -83,13 +126,16 @@ func setMouseConfig(c *gin.Context) {
 		return
 	}
 
-	if _, ok := macros.Macros[value]; !ok {
+	if !macros.IsValidBinding(value) {
 		c.String(http.StatusBadRequest, "Invalid macro Name")
 		return
 	}
 
 	bkey, _ := strconv.ParseUint(key, 10, 8)
 	logger.Logger.Infof("Set mouse config: %d -> %s", bkey, value)
+	if _, ok := macros.MouseConfigDict[devName]; !ok {
+		macros.MouseConfigDict[devName] = make(map[byte]string)
+	}
 	macros.MouseConfigDict[devName][byte(bkey)] = value
 	c.String(http.StatusOK, "ok")
 }
@@ -120,7 +166,7 @@ func setKeyboardConfig(c *gin.Context) {
 		return
 	}
 
-	if _, ok := macros.Macros[value]; !ok {
+	if !macros.IsValidBindingLocked(value) {
 		c.String(http.StatusBadRequest, "Invalid macro Name")
 		return
 	}
@@ -130,3 +176,239 @@ func setKeyboardConfig(c *gin.Context) {
 	macros.KeyboardConfigDict[byte(bkey)] = value
 	c.String(http.StatusOK, "ok")
 }
+
+func getDevices(c *gin.Context) {
+	c.JSON(http.StatusOK, device.Devices.List())
+}
+
+func getLatency(c *gin.Context) {
+	c.JSON(http.StatusOK, latency.Snapshot())
+}
+
+func resetLatency(c *gin.Context) {
+	latency.Reset()
+	c.String(http.StatusOK, "ok")
+}
+
+func getSensitivity(c *gin.Context) {
+	c.JSON(http.StatusOK, sensitivity.Get())
+}
+
+// setSensitivity 只修改传入的参数，devName 为空时修改默认值
+func setSensitivity(c *gin.Context) {
+	devName := strings.ToLower(c.Query("devName"))
+	current := sensitivity.Get()
+	profile, ok := current.Devices[devName]
+	if !ok {
+		profile = current.Default
+	}
+	for _, axis := range []string{"x", "y", "power"} {
+		value := c.Query(axis)
+		if value == "" {
+			continue
+		}
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			c.String(http.StatusBadRequest, "Invalid %s", axis)
+			return
+		}
+		switch axis {
+		case "x":
+			profile.X = &f
+		case "y":
+			profile.Y = &f
+		case "power":
+			profile.Power = f
+		}
+	}
+	if curve := c.Query("curve"); curve != "" {
+		profile.Curve = curve
+	}
+	if table := c.Query("table"); table != "" {
+		points, err := sensitivity.ParseTable(table)
+		if err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		profile.Table = points
+	}
+	if err := sensitivity.Set(devName, profile); err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	logger.Logger.Infof("Set sensitivity: %q -> %+v", devName, profile)
+	c.String(http.StatusOK, "ok")
+}
+
+// startRecord 开始录制宏，name 必填，devName 为空时录制所有设备
+func startRecord(c *gin.Context) {
+	opts := recorder.Options{
+		Name:        c.Query("name"),
+		Description: c.Query("description"),
+		Device:      c.Query("devName"),
+		DropMotion:  c.Query("dropMotion") == "true",
+		StopKey:     c.Query("stopKey"),
+	}
+	if q := c.Query("quantize"); q != "" {
+		d, err := time.ParseDuration(q)
+		if err != nil || d < 0 {
+			c.String(http.StatusBadRequest, "Invalid quantize")
+			return
+		}
+		opts.Quantize = d
+	}
+	if err := recorder.Start(opts); err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	c.String(http.StatusOK, "ok")
+}
+
+func stopRecord(c *gin.Context) {
+	def, err := recorder.Stop()
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, def)
+}
+
+func getRecord(c *gin.Context) {
+	c.JSON(http.StatusOK, recorder.Get())
+}
+
+// typeText 在目标端输入文本，输入完成后返回，layout 和 delay 为空时使用配置值
+func typeText(c *gin.Context) {
+	mk := macroKB.Load()
+	if mk == nil {
+		c.String(http.StatusServiceUnavailable, "controller not ready")
+		return
+	}
+	var delay time.Duration
+	if d := c.Query("delay"); d != "" {
+		var err error
+		if delay, err = time.ParseDuration(d); err != nil || delay < 0 {
+			c.String(http.StatusBadRequest, "Invalid delay")
+			return
+		}
+	}
+	if err := mk.TypeText(c.Query("text"), c.Query("layout"), delay, c.Request.Context().Done()); err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	c.String(http.StatusOK, "ok")
+}
+
+func getLayouts(c *gin.Context) {
+	c.JSON(http.StatusOK, input.LayoutNames())
+}
+
+// getLayers 返回所有层及当前激活的层(优先级从高到低)
+func getLayers(c *gin.Context) {
+	macros.LayerMutex.RLock()
+	layers := make([]macros.LayerStatus, 0, len(macros.Layers))
+	for _, l := range macros.Layers {
+		layers = append(layers, *l)
+	}
+	macros.LayerMutex.RUnlock()
+	c.JSON(http.StatusOK, gin.H{"layers": layers, "active": macros.ActiveLayers()})
+}
+
+// setLayer 打开或关闭层，on 为 true/false
+func setLayer(c *gin.Context) {
+	on, err := strconv.ParseBool(c.Query("on"))
+	if err != nil {
+		c.String(http.StatusBadRequest, "Invalid on")
+		return
+	}
+	if err := macros.SetLayer(c.Query("name"), on); err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	logger.Logger.Infof("Set layer: %s -> %t", c.Query("name"), on)
+	c.String(http.StatusOK, "ok")
+}
+
+func getRunningMacros(c *gin.Context) {
+	mk := macroKB.Load()
+	if mk == nil {
+		c.JSON(http.StatusOK, []*macros.Invocation{})
+		return
+	}
+	c.JSON(http.StatusOK, mk.Running())
+}
+
+// cancelMacro 停止某次宏调用，id 为 all 时停止全部
+func cancelMacro(c *gin.Context) {
+	mk := macroKB.Load()
+	if mk == nil {
+		c.String(http.StatusServiceUnavailable, "controller not ready")
+		return
+	}
+	id := c.Query("id")
+	if id == "all" {
+		mk.CancelAll()
+		c.String(http.StatusOK, "ok")
+		return
+	}
+	n, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		c.String(http.StatusBadRequest, "Invalid id")
+		return
+	}
+	if err := mk.CancelMacro(n); err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	logger.Logger.Infof("Cancel macro invocation: %d", n)
+	c.String(http.StatusOK, "ok")
+}
+
+// getScripts 返回最近一次加载脚本宏的结果
+func getScripts(c *gin.Context) {
+	c.JSON(http.StatusOK, macros.GetScriptStatus())
+}
+
+// reloadScripts 重新加载脚本目录，出错的脚本保留旧版本
+func reloadScripts(c *gin.Context) {
+	c.JSON(http.StatusOK, macros.LoadScripts())
+}
+
+// getPlugins 返回插件进程和连接的状态
+func getPlugins(c *gin.Context) {
+	host := pluginHost.Load()
+	if host == nil {
+		c.JSON(http.StatusOK, plugin.Status{Processes: []plugin.ProcessStatus{}, Connections: []plugin.ConnStatus{}})
+		return
+	}
+	c.JSON(http.StatusOK, host.Status())
+}
+
+// getMacroStatus 返回各个来源(文件、插件)提供的宏和加载错误
+func getMacroStatus(c *gin.Context) {
+	c.JSON(http.StatusOK, macros.GetLoadStatus())
+}
+
+// reloadMacros 重新加载压枪配置、宏目录和脚本目录，出错的文件保留原来的宏
+func reloadMacros(c *gin.Context) {
+	c.JSON(http.StatusOK, macros.ReloadAll())
+}
+
+func getProfiles(c *gin.Context) {
+	c.JSON(http.StatusOK, macros.GetProfiles())
+}
+
+// setProfile 切换方案，name 为 next 时切换到下一个，切换前松开所有按键并停止运行中的宏
+func setProfile(c *gin.Context) {
+	mk := macroKB.Load()
+	if mk == nil {
+		c.String(http.StatusServiceUnavailable, "controller not ready")
+		return
+	}
+	if err := mk.SwitchProfile(c.Query("name")); err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	logger.Logger.Infof("Set profile: %s", c.Query("name"))
+	c.JSON(http.StatusOK, macros.GetProfiles())
+}
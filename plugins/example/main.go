@@ -0,0 +1,102 @@
+// 示例插件: 注册一个按住连点左键的宏。
+//
+//	go build -o plugins/example/example ./plugins/example
+//
+// 然后在 config.yaml 的 plugins.processes 中配置 command: ["plugins/example/example"]，
+// 也可以单独运行(需要 input2com 已启动): INPUT2COM_PLUGIN_SOCKET=/tmp/input2com.sock ./example
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"input2com/internal/macros"
+	"input2com/internal/plugin"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type client struct {
+	mutex  sync.Mutex
+	enc    *json.Encoder
+	nextID int
+}
+
+// call 发送请求，响应由 main 中的读取循环处理
+func (c *client) call(method string, params any) {
+	data, _ := json.Marshal(params)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.nextID++
+	id := json.RawMessage(strconv.Itoa(c.nextID))
+	if err := c.enc.Encode(plugin.Message{JSONRPC: "2.0", ID: &id, Method: method, Params: data}); err != nil {
+		log.Fatalf("发送 %s 失败: %v", method, err)
+	}
+}
+
+func main() {
+	socket := os.Getenv(plugin.SocketEnv)
+	if socket == "" {
+		socket = "/tmp/input2com.sock"
+	}
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		log.Fatalf("连接 %s 失败: %v", socket, err)
+	}
+	c := &client{enc: json.NewEncoder(conn)}
+	c.call("register", plugin.RegisterParams{
+		Name: "example",
+		Macros: []plugin.MacroInfo{
+			{
+				Name:        "example_autoclick",
+				Description: "插件示例: 按住连点左键",
+				Params:      []macros.Param{{Name: "interval", Type: macros.ParamDuration, Default: "100ms"}},
+			},
+		},
+	})
+
+	stops := make(map[uint64]chan struct{})
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		var msg plugin.Message
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			log.Printf("无法解析消息: %v", err)
+			continue
+		}
+		if msg.Error != nil {
+			log.Printf("请求失败: %s", msg.Error.Message)
+			continue
+		}
+		var ev plugin.MacroEvent
+		json.Unmarshal(msg.Params, &ev)
+		switch msg.Method {
+		case "macro.press":
+			stop := make(chan struct{})
+			stops[ev.ID] = stop
+			interval, err := time.ParseDuration(ev.Args["interval"])
+			if err != nil {
+				interval = 100 * time.Millisecond
+			}
+			go func() {
+				for {
+					select {
+					case <-stop:
+						return
+					default:
+						c.call("key.tap", plugin.KeyParams{Key: "left", Hold: 15})
+						time.Sleep(interval)
+					}
+				}
+			}()
+		case "macro.release":
+			if stop, ok := stops[ev.ID]; ok {
+				close(stop)
+				delete(stops, ev.ID)
+			}
+		}
+	}
+	log.Printf("连接已断开: %v", scanner.Err())
+}